@@ -0,0 +1,228 @@
+package jsonnext
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"foxygo.at/s/errs"
+)
+
+// Filenames of the jsonnet-bundler manifest and lock file.
+const (
+	JsonnetFile     = "jsonnetfile.json"
+	JsonnetLockFile = "jsonnetfile.lock.json"
+)
+
+const defaultBundleVersion = "master" // default git ref used by jsonnet-bundler
+
+// DefaultRawURLs are the netpath templates for the raw content of files in
+// git repositories on well-known hosts. See Bundle.RawURLs.
+func DefaultRawURLs() map[string]string {
+	return map[string]string{
+		"github.com": "//github.com/{repo}/raw/{version}/{path}",
+		"gitlab.com": "//gitlab.com/{repo}/-/raw/{version}/{path}",
+	}
+}
+
+// Bundle maps the import paths of jsonnet-bundler dependencies to the
+// locations of their sources, so that they can be imported without running
+// "jb install" to populate a vendor directory.
+//
+// A git dependency with the remote "https://github.com/org/repo.git" and
+// subdir "lib" is imported by jsonnet-bundler with paths starting with
+// "github.com/org/repo/lib". Bundle resolves these paths to netpaths of the
+// raw content of the file in the repository at the version pinned in the lock
+// file, or the version in the manifest if the dependency is not locked. With
+// legacy imports, the dependency can also be imported by its name, "lib" in
+// this example. Local dependencies are imported by their name and are
+// resolved to their local directory.
+type Bundle struct {
+	// RawURLs maps git hosts to a template of the netpath of the raw
+	// content of a file in a repository on that host. The template
+	// placeholders "{repo}", "{version}" and "{path}" are replaced with the
+	// repository path on the host, the git ref of the dependency and the
+	// path of the file in the repository. Dependencies on hosts without a
+	// template cannot be resolved.
+	RawURLs map[string]string
+
+	deps []bundleDep // sorted by descending length of prefix
+}
+
+type bundleDep struct {
+	prefix  string // import path prefix
+	host    string // git host, empty for local dependencies
+	repo    string // repository path on host, or local directory
+	subdir  string
+	version string
+}
+
+// jsonnet-bundler file format of jsonnetfile.json and jsonnetfile.lock.json.
+type jbFile struct {
+	Dependencies  []jbDependency `json:"dependencies"`
+	LegacyImports *bool          `json:"legacyImports"`
+}
+
+type jbDependency struct {
+	Source struct {
+		Git *struct {
+			Remote string `json:"remote"`
+			Subdir string `json:"subdir"`
+		} `json:"git"`
+		Local *struct {
+			Directory string `json:"directory"`
+		} `json:"local"`
+	} `json:"source"`
+	Version string `json:"version"`
+	Name    string `json:"name"`
+}
+
+// LoadBundle reads the jsonnet-bundler manifest (jsonnetfile.json) and the
+// lock file (jsonnetfile.lock.json) in dir and returns a Bundle of the
+// dependencies they declare. The lock file is optional; dependencies only
+// in the lock file, the transitive dependencies of the manifest, are
+// included too. Local dependency directories are relative to dir.
+func LoadBundle(dir string) (*Bundle, error) {
+	var manifest, lock jbFile
+	if err := readJSONFile(filepath.Join(dir, JsonnetFile), &manifest); err != nil {
+		return nil, err
+	}
+	if err := readJSONFile(filepath.Join(dir, JsonnetLockFile), &lock); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	locked := map[string]string{}
+	for _, d := range lock.Dependencies {
+		if dep, ok := newBundleDep(dir, d); ok {
+			locked[dep.prefix] = dep.version
+		}
+	}
+
+	b := &Bundle{RawURLs: DefaultRawURLs()}
+	legacy := manifest.LegacyImports == nil || *manifest.LegacyImports
+	seen := map[string]bool{}
+	add := func(d jbDependency) {
+		dep, ok := newBundleDep(dir, d)
+		if !ok || seen[dep.prefix] {
+			return
+		}
+		seen[dep.prefix] = true
+		if v, ok := locked[dep.prefix]; ok {
+			dep.version = v
+		}
+		b.deps = append(b.deps, dep)
+		if name := legacyName(d, dep); legacy && dep.host != "" && name != "" {
+			alias := dep
+			alias.prefix = name
+			b.deps = append(b.deps, alias)
+		}
+	}
+	for _, d := range manifest.Dependencies {
+		add(d)
+	}
+	// The lock file also pins the transitive dependencies, which are
+	// imported by the vendored direct dependencies.
+	for _, d := range lock.Dependencies {
+		add(d)
+	}
+	sort.SliceStable(b.deps, func(i, j int) bool { return len(b.deps[i].prefix) > len(b.deps[j].prefix) })
+	return b, nil
+}
+
+// Resolve returns the location of the jsonnet-bundler import path imp and
+// true, or false if imp is not in a dependency of the Bundle.
+func (b *Bundle) Resolve(imp string) (string, bool) {
+	for _, dep := range b.deps {
+		if !matchPrefix(imp, dep.prefix) || imp == dep.prefix {
+			continue
+		}
+		rest := strings.TrimPrefix(imp[len(dep.prefix):], "/")
+		if dep.host == "" {
+			return path.Join(dep.repo, rest), true
+		}
+		tmpl, ok := b.RawURLs[dep.host]
+		if !ok {
+			return "", false
+		}
+		r := strings.NewReplacer("{repo}", dep.repo, "{version}", dep.version, "{path}", path.Join(dep.subdir, rest))
+		return r.Replace(tmpl), true
+	}
+	return "", false
+}
+
+func newBundleDep(dir string, d jbDependency) (bundleDep, bool) {
+	switch {
+	case d.Source.Git != nil:
+		host, repo := parseGitRemote(d.Source.Git.Remote)
+		if host == "" || repo == "" {
+			return bundleDep{}, false
+		}
+		subdir := strings.Trim(d.Source.Git.Subdir, "/")
+		version := d.Version
+		if version == "" {
+			version = defaultBundleVersion
+		}
+		return bundleDep{
+			prefix:  path.Join(host, repo, subdir),
+			host:    host,
+			repo:    repo,
+			subdir:  subdir,
+			version: version,
+		}, true
+	case d.Source.Local != nil:
+		directory := filepath.Join(dir, d.Source.Local.Directory)
+		name := d.Name
+		if name == "" {
+			name = filepath.Base(directory)
+		}
+		return bundleDep{prefix: name, repo: filepath.ToSlash(directory)}, true
+	}
+	return bundleDep{}, false
+}
+
+// legacyName returns the name under which jsonnet-bundler makes a git
+// dependency available with legacy imports: its explicit name, or the last
+// element of its subdir or repository.
+func legacyName(d jbDependency, dep bundleDep) string {
+	switch {
+	case d.Name != "":
+		return d.Name
+	case dep.subdir != "":
+		return path.Base(dep.subdir)
+	}
+	return path.Base(dep.repo)
+}
+
+// parseGitRemote splits a git remote URL such as
+// "https://github.com/org/repo.git", "ssh://git@github.com/org/repo" or
+// "git@github.com:org/repo.git" into its host and repository path.
+func parseGitRemote(remote string) (host, repo string) {
+	if i := strings.Index(remote, "://"); i >= 0 {
+		remote = remote[i+3:]
+	} else {
+		remote = strings.Replace(remote, ":", "/", 1)
+	}
+	if i := strings.Index(remote, "@"); i >= 0 {
+		remote = remote[i+1:]
+	}
+	parts := strings.SplitN(strings.TrimSuffix(remote, ".git"), "/", 2)
+	if len(parts) != 2 {
+		return "", ""
+	}
+	return parts[0], strings.Trim(parts[1], "/")
+}
+
+func readJSONFile(filename string, v interface{}) error {
+	b, err := ioutil.ReadFile(filename) //nolint:gosec // We want to read user specified files.
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return errs.Errorf("%s: %v", filename, err)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package jsonnext
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBundleResolve(t *testing.T) {
+	b, err := LoadBundle("testdata/bundle")
+	require.NoError(t, err)
+
+	const grafonnet = "//github.com/grafana/grafonnet-lib/raw/3626fc4dcefe3bcd1ba6b2e5aa4e5e2e2f0e5d8b/grafonnet/grafana.libsonnet"
+	tests := map[string]struct {
+		imp      string
+		expected string // empty if not resolved
+	}{
+		"locked":           {"github.com/grafana/grafonnet-lib/grafonnet/grafana.libsonnet", grafonnet},
+		"legacy-subdir":    {"grafonnet/grafana.libsonnet", grafonnet},
+		"unlocked-gitlab":  {"gitlab.com/org/repo/a/b.libsonnet", "//gitlab.com/org/repo/-/raw/v1.0.0/a/b.libsonnet"},
+		"legacy-repo":      {"repo/a/b.libsonnet", "//gitlab.com/org/repo/-/raw/v1.0.0/a/b.libsonnet"},
+		"local":            {"local/lib.libsonnet", "testdata/bundle/local/lib.libsonnet"},
+		"lock-only":        {"github.com/jsonnet-libs/xtd/main.libsonnet", "//github.com/jsonnet-libs/xtd/raw/0256a910ac71f0f842696d7bca0bf01ea77eb654/main.libsonnet"},
+		"legacy-lock-only": {"xtd/main.libsonnet", "//github.com/jsonnet-libs/xtd/raw/0256a910ac71f0f842696d7bca0bf01ea77eb654/main.libsonnet"},
+		"unknown-host":     {"example.com/org/other/x.libsonnet", ""},
+		"legacy-name":      {"other/x.libsonnet", ""},
+		"not-a-dep":        {"github.com/grafana/grafonnet-lib/other/x.libsonnet", ""},
+		"prefix-only":      {"grafonnet", ""},
+		"element-prefix":   {"grafonnet-lib/x.libsonnet", ""},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			location, ok := b.Resolve(tc.imp)
+			require.Equal(t, tc.expected != "", ok)
+			require.Equal(t, tc.expected, location)
+		})
+	}
+}
+
+func TestBundleCustomRawURL(t *testing.T) {
+	b, err := LoadBundle("testdata/bundle")
+	require.NoError(t, err)
+	b.RawURLs["example.com"] = "//raw.example.com/{repo}/{version}/{path}"
+	location, ok := b.Resolve("other/x.libsonnet")
+	require.True(t, ok)
+	require.Equal(t, "//raw.example.com/org/other/master/x.libsonnet", location)
+}
+
+func TestLoadBundleError(t *testing.T) {
+	_, err := LoadBundle("testdata/nonexistent")
+	require.Error(t, err)
+	_, err = LoadBundle("testdata/config")
+	require.Error(t, err)
+}
+
+func TestParseGitRemote(t *testing.T) {
+	tests := map[string]struct{ remote, host, repo string }{
+		"https":      {"https://github.com/org/repo.git", "github.com", "org/repo"},
+		"https-bare": {"https://github.com/org/repo", "github.com", "org/repo"},
+		"ssh":        {"ssh://git@github.com/org/repo.git", "github.com", "org/repo"},
+		"scp":        {"git@github.com:org/repo.git", "github.com", "org/repo"},
+		"nested":     {"https://gitlab.com/group/sub/repo.git", "gitlab.com", "group/sub/repo"},
+		"invalid":    {"repo", "", ""},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			host, repo := parseGitRemote(tc.remote)
+			require.Equal(t, tc.host, host)
+			require.Equal(t, tc.repo, repo)
+		})
+	}
+}
+
+func TestImportBundle(t *testing.T) {
+	s := httptest.NewTLSServer(fileMap{"/grafana/grafonnet-lib/raw/3626fc4dcefe3bcd1ba6b2e5aa4e5e2e2f0e5d8b/grafonnet/grafana.libsonnet": "{}\n"})
+	defer s.Close()
+	np := strings.TrimPrefix(s.URL, "https:")
+
+	b, err := LoadBundle("testdata/bundle")
+	require.NoError(t, err)
+	b.RawURLs["github.com"] = np + "/{repo}/raw/{version}/{path}"
+	i := Importer{Fetcher: s.Client(), Bundle: b}
+
+	contents, foundAt, err := i.Import("main.jsonnet", "github.com/grafana/grafonnet-lib/grafonnet/grafana.libsonnet")
+	require.NoError(t, err)
+	require.Equal(t, "{}\n", contents.String())
+	require.Equal(t, np+"/grafana/grafonnet-lib/raw/3626fc4dcefe3bcd1ba6b2e5aa4e5e2e2f0e5d8b/grafonnet/grafana.libsonnet", foundAt)
+
+	contents, foundAt, err = i.Import("main.jsonnet", "local/lib.libsonnet")
+	require.NoError(t, err)
+	require.Equal(t, "\"local lib\"\n", contents.String())
+	require.Equal(t, "testdata/bundle/local/lib.libsonnet", foundAt)
+}
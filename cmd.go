@@ -0,0 +1,179 @@
+package jsonnext
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"foxygo.at/s/errs"
+)
+
+// CommandTimeout is the maximum time a command run for a VMVar by
+// NewExtStrCmd or NewTLAStrCmd may take, and the default for
+// Config.CommandTimeout.
+const CommandTimeout = 30 * time.Second
+
+// ErrCommandsDisabled is returned when setting a VMVar from the output of a
+// command in a Config with NoCommands set, by Config.SetExtStrCmd,
+// Config.SetTLAStrCmd and the flags using them.
+var ErrCommandsDisabled = errors.New("vars from commands are disabled")
+
+// CommandError is returned when a command run for a VMVar fails, times out or
+// exits with a non-zero status.
+type CommandError struct {
+	Command string
+	Stderr  string        // trimmed standard error output of the command
+	Timeout time.Duration // the timeout the command was run with
+	Err     error         // an *exec.ExitError, context.DeadlineExceeded or the error starting the command
+}
+
+// Error returns the error message of e, including the standard error output
+// of the command.
+func (e *CommandError) Error() string {
+	msg := "command " + strconv.Quote(e.Command) + ": "
+	if errors.Is(e.Err, context.DeadlineExceeded) {
+		msg += "timed out after " + e.Timeout.String()
+	} else {
+		msg += e.Err.Error()
+	}
+	if e.Stderr != "" {
+		msg += ": " + e.Stderr
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error of e.
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// NewExtStrCmd runs command with the shell (sh, or cmd on Windows) and
+// constructs a VMVar as a string external variable from its standard output
+// with leading and trailing whitespace removed. The command is run once, when
+// NewExtStrCmd is called, and the VMVar is the same as one constructed with
+// NewExtStr from the output, so it is the output that is serialised by
+// Config.MarshalJSON and Config.Args. If the command does not succeed within
+// CommandTimeout, a *CommandError is returned.
+func NewExtStrCmd(command string) (VMVar, error) {
+	out, err := runCommand(command, CommandTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return NewExtStr(out), nil
+}
+
+// NewTLAStrCmd runs command and constructs a VMVar as a string top-level arg
+// from its output, as described for NewExtStrCmd.
+func NewTLAStrCmd(command string) (VMVar, error) {
+	out, err := runCommand(command, CommandTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return NewTLAStr(out), nil
+}
+
+// SetExtStrCmd sets an extVar string in c from the output of a command,
+// parsing the key and command from v as "key=command" as described for
+// VMVarMap.SetCmdVar. The command is run with c.CommandTimeout. An error
+// wrapping ErrCommandsDisabled is returned without running the command if
+// c.NoCommands is true.
+func (c *Config) SetExtStrCmd(v string) error {
+	if c.ExtVars == nil {
+		c.ExtVars = VMVarMap{}
+	}
+	return c.setCmdVar(c.ExtVars, v, NewExtStr)
+}
+
+// SetTLAStrCmd sets a top-level arg string in c from the output of a
+// command, as described for SetExtStrCmd.
+func (c *Config) SetTLAStrCmd(v string) error {
+	if c.TLAVars == nil {
+		c.TLAVars = VMVarMap{}
+	}
+	return c.setCmdVar(c.TLAVars, v, NewTLAStr)
+}
+
+func (c *Config) setCmdVar(m VMVarMap, v string, makevar func(string) VMVar) error {
+	if c.NoCommands {
+		return errs.Errorf("%v: %s", ErrCommandsDisabled, v)
+	}
+	timeout := c.CommandTimeout
+	if timeout == 0 {
+		timeout = CommandTimeout
+	}
+	return m.SetCmdVar(v, func(command string) (VMVar, error) {
+		out, err := runCommand(command, timeout)
+		if err != nil {
+			return nil, err
+		}
+		return makevar(out), nil
+	})
+}
+
+// SetCmdVar sets a variable in m parsing the key and a command from the given
+// string v as "key=command", using makevar, typically NewExtStrCmd or
+// NewTLAStrCmd, to run the command and construct the VMVar. An error is
+// returned if the key or command is missing, or if makevar returns an error.
+//
+// SetCmdVar does not know of a Config, so it does not check NoCommands or
+// use CommandTimeout. Use Config.SetExtStrCmd or Config.SetTLAStrCmd to set
+// a var in a Config.
+func (m VMVarMap) SetCmdVar(v string, makevar func(string) (VMVar, error)) error {
+	parts := strings.SplitN(v, "=", 2)
+	if parts[0] == "" {
+		return errs.Errorf(`%v in "%s"`, ErrMissingKey, v)
+	}
+	if len(parts) == 1 || strings.TrimSpace(parts[1]) == "" {
+		return errs.Errorf("%v: command for %s", ErrMissingValue, parts[0])
+	}
+	vmvar, err := makevar(parts[1])
+	if err != nil {
+		return errs.Errorf("%s: %v", parts[0], err)
+	}
+	m[parts[0]] = vmvar
+	return nil
+}
+
+// runCommand runs command with the shell, returning its trimmed standard
+// output or a *CommandError if it does not succeed within timeout.
+//
+// The shell runs in a process group of its own, which is killed on timeout.
+// Killing only the shell would leave the processes it started running, and
+// holding its output open, so the command would not stop at the timeout.
+func runCommand(command string, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	shell := []string{"sh", "-c"}
+	if runtime.GOOS == "windows" {
+		shell = []string{"cmd", "/C"}
+	}
+	cmd := exec.Command(shell[0], append(shell[1:], command)...) //nolint:gosec // Running user commands is the point.
+	setProcessGroup(cmd)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Start()
+	if err == nil {
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				killProcessGroup(cmd)
+			case <-done:
+			}
+		}()
+		err = cmd.Wait()
+		close(done)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return "", &CommandError{Command: command, Stderr: strings.TrimSpace(stderr.String()), Timeout: timeout, Err: err}
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"foxygo.at/jsonnext"
+	"github.com/alecthomas/kong"
+)
+
+type completionCmd struct {
+	Shell string `arg:"" enum:"bash,zsh,fish" help:"Shell to write the completion script for: bash, zsh or fish"`
+}
+
+// Run writes the completion script for the selected shell to stdout.
+func (c *completionCmd) Run() error {
+	_, err := fmt.Print(completionScripts[c.Shell])
+	return err
+}
+
+type completeCmd struct {
+	Words []string `arg:"" optional:"" help:"Index of the word being completed, followed by the words of the command line after jnx"`
+}
+
+// Run writes the completions for a word of the command line to stdout, one
+// per line. It is run by the completion scripts as "jnx __complete <index>
+// <words>", with the words on the command line after "jnx" and the index of
+// the word being completed among them. That word ends at the cursor.
+func (c *completeCmd) Run(ctx *kong.Context) error {
+	if len(c.Words) == 0 {
+		return nil
+	}
+	cword, err := strconv.Atoi(c.Words[0])
+	if err != nil {
+		return err
+	}
+	for _, completion := range completeArgs(ctx.Model.Node, c.Words[1:], cword) {
+		if _, err := fmt.Println(completion); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// argScan holds the state of scanning the words of a jnx command line, as
+// splitScriptArgs does, for completion.
+type argScan struct {
+	root     *kong.Node
+	byName   map[string]*kong.Flag
+	filename string
+	pending  *kong.Flag // flag waiting for its value
+	dashdash bool
+	scriptDD bool // "--" after the filename, so all words are script args
+	cmdSeen  bool // the eval command was given by name
+	otherCmd bool // a command other than eval was given
+}
+
+func newArgScan(root *kong.Node) *argScan {
+	byName := map[string]*kong.Flag{}
+	for _, f := range evalFlags(root) {
+		if f.Hidden {
+			continue
+		}
+		byName["--"+f.Name] = f
+		if f.Short != 0 {
+			byName["-"+string(f.Short)] = f
+		}
+	}
+	return &argScan{root: root, byName: byName}
+}
+
+// scan updates s with the next word of the command line.
+func (s *argScan) scan(arg string) {
+	switch {
+	case s.pending != nil:
+		s.pending = nil
+	case s.otherCmd || s.scriptDD:
+	case s.filename != "":
+		// jnx flags are still jnx flags after the filename.
+		if arg == "--" {
+			s.scriptDD = true
+		} else if f := s.byName[arg]; f != nil && !f.IsBool() {
+			s.pending = f
+		}
+	case arg == "--" && !s.dashdash:
+		s.dashdash = true
+	case !s.dashdash && strings.HasPrefix(arg, "-") && arg != "-":
+		if f := s.byName[arg]; f != nil && !f.IsBool() {
+			s.pending = f
+		}
+	case !s.cmdSeen && arg == s.root.DefaultCmd.Name:
+		s.cmdSeen = true
+	case !s.cmdSeen && command(s.root, arg) != nil:
+		s.otherCmd = true
+	default:
+		s.filename = arg
+	}
+}
+
+// completeArgs returns the completions for args[cword], the word being
+// completed, given the other words of the command line and root, the kong
+// model of jnx. Flag names of the eval command are completed for words
+// starting with "-", and file paths and the other commands for the filename.
+// The values of flags taking files complete file paths, and directories for
+// flags taking a dir, such as -J. The TLA flags complete the names of the
+// parameters of the top-level function of the filename, which usually comes
+// after them. After the filename, the parameters are completed as script
+// args, and the values of jnx flags as before it. The args of commands other
+// than eval are not completed.
+func completeArgs(root *kong.Node, args []string, cword int) []string {
+	if cword < 0 || cword >= len(args) {
+		return nil
+	}
+	s := newArgScan(root)
+	for _, arg := range args[:cword] {
+		s.scan(arg)
+	}
+	if s.otherCmd {
+		return nil
+	}
+	// The filename may come after the word being completed.
+	rest := *s
+	for _, arg := range args[cword:] {
+		rest.scan(arg)
+	}
+	filename := rest.filename
+
+	word := args[cword]
+	flagValue := !s.scriptDD && (s.filename != "" || !s.dashdash) && strings.HasPrefix(word, "--") && strings.Contains(word, "=")
+	var flag *kong.Flag
+	if flagValue {
+		flag = s.byName[word[:strings.Index(word, "=")]]
+	}
+	var completions []string
+	switch {
+	case s.pending != nil:
+		completions = completeFlagValue(s.pending, word, filename)
+	case flag != nil && !flag.IsBool():
+		i := strings.Index(word, "=")
+		completions = prefixAll(word[:i+1], completeFlagValue(flag, word[i+1:], filename))
+	case flagValue && s.filename == "":
+	case s.filename != "":
+		if strings.HasPrefix(word, "-") {
+			completions = scriptParams(s.filename)
+		}
+	case !s.dashdash && strings.HasPrefix(word, "-"):
+		for name := range s.byName {
+			completions = append(completions, name)
+		}
+	default:
+		completions = completePaths(word, false)
+		if !s.cmdSeen {
+			for _, child := range root.Children {
+				if child.Type == kong.CommandNode && !child.Hidden && child != root.DefaultCmd {
+					completions = append(completions, child.Name)
+				}
+			}
+		}
+	}
+	return filterPrefix(completions, word)
+}
+
+// completeFlagValue returns the completions for val as the value of the flag
+// f, based on the placeholder of the flag. filename is the file being
+// evaluated, whose parameters are the completions for the names of the TLA
+// flags.
+func completeFlagValue(f *kong.Flag, val, filename string) []string {
+	ph := f.PlaceHolder
+	switch {
+	case ph == "dir":
+		return completePaths(val, true)
+	case ph == "filename" || ph == "file":
+		return completePaths(val, false)
+	case strings.HasPrefix(ph, "var"):
+		if i := strings.Index(val, "="); i >= 0 {
+			if strings.HasSuffix(ph, "[=filename]") || strings.HasSuffix(ph, "[=file]") {
+				return prefixAll(val[:i+1], completePaths(val[i+1:], false))
+			}
+			return nil
+		}
+		if strings.HasPrefix(f.Name, "tla-") {
+			return suffixAll(paramNames(filename), "=")
+		}
+	}
+	return nil
+}
+
+// completePaths returns the files and directories starting with prefix, or
+// only the directories if dirsOnly is true. Directories end with a slash so
+// that completion continues inside them. Hidden files are only completed if
+// prefix names them with a leading dot.
+func completePaths(prefix string, dirsOnly bool) []string {
+	dir, base := filepath.Split(prefix)
+	readDir := dir
+	if readDir == "" {
+		readDir = "."
+	}
+	infos, err := ioutil.ReadDir(readDir)
+	if err != nil {
+		return nil
+	}
+	var paths []string
+	for _, fi := range infos {
+		name := fi.Name()
+		if !strings.HasPrefix(name, base) || (strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".")) {
+			continue
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if target, err := os.Stat(filepath.Join(readDir, name)); err == nil {
+				fi = target
+			}
+		}
+		switch {
+		case fi.IsDir():
+			paths = append(paths, dir+name+string(filepath.Separator))
+		case !dirsOnly:
+			paths = append(paths, dir+name)
+		}
+	}
+	return paths
+}
+
+// paramNames returns the names of the parameters of the top-level function
+// of filename, or nil if there are none or the file cannot be parsed.
+func paramNames(filename string) []string {
+	if filename == "" || filename == "-" {
+		return nil
+	}
+	params, err := jsonnext.FileParams(filename)
+	if err != nil {
+		return nil
+	}
+	names := make([]string, len(params))
+	for i, p := range params {
+		names[i] = p.Name
+	}
+	return names
+}
+
+// scriptParams returns the parameters of the top-level function of filename
+// as the script args after the filename, and the help flag for them.
+func scriptParams(filename string) []string {
+	return append(prefixAll("--", paramNames(filename)), "--help")
+}
+
+func prefixAll(prefix string, ss []string) []string {
+	out := make([]string, len(ss))
+	for i, s := range ss {
+		out[i] = prefix + s
+	}
+	return out
+}
+
+func suffixAll(ss []string, suffix string) []string {
+	out := make([]string, len(ss))
+	for i, s := range ss {
+		out[i] = s + suffix
+	}
+	return out
+}
+
+// filterPrefix returns the strings in ss that start with prefix, sorted.
+func filterPrefix(ss []string, prefix string) []string {
+	var out []string
+	for _, s := range ss {
+		if strings.HasPrefix(s, prefix) {
+			out = append(out, s)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// completionScripts are the completion scripts for each shell, written by
+// "jnx completion <shell>". They run "jnx __complete" with the index of the
+// word at the cursor and the words of the command line, which writes the
+// completions one per line.
+// Completions ending in "/" or "=" are not followed by a space so that the
+// path or value can be continued.
+var completionScripts = map[string]string{
+	"bash": `# bash completion for jnx. Load it with:
+#   source <(jnx completion bash)
+_jnx_complete() {
+    # COMP_WORDS is also split at the characters of COMP_WORDBREAKS, such
+    # as '=' and ':'. Rejoin the parts that are not separated by whitespace
+    # in the line, so that --flag=value is one word, and cut the current
+    # word at the cursor.
+    local line=$COMP_LINE w sep i n=0 cword=0 cut=0
+    local -a words=()
+    for ((i = 0; i < ${#COMP_WORDS[@]}; i++)); do
+        w=${COMP_WORDS[i]}
+        if ((i == COMP_CWORD)) && [[ -z $w ]]; then
+            words+=("")
+            cword=$((${#words[@]} - 1))
+            continue
+        fi
+        sep=${line%%[![:space:]]*}
+        line=${line#"$sep"}
+        line=${line#"$w"}
+        ((n += ${#sep} + ${#w}))
+        if [[ -n $sep || ${#words[@]} -eq 0 ]]; then
+            cut=0
+            words+=("$w")
+        elif ((cut)); then
+            continue
+        else
+            words[${#words[@]}-1]+=$w
+        fi
+        if ((i == COMP_CWORD)); then
+            cword=$((${#words[@]} - 1))
+            w=${words[cword]}
+            words[cword]=${w:0:${#w}-(n-COMP_POINT)}
+            cut=1
+        fi
+    done
+    # bash completes the text after the last '=' or ':' of the word, so
+    # remove what comes before it from the completions.
+    local word=${words[cword]} prefix=
+    [[ $word == *[=:]* ]] && prefix=${word%"${word##*[=:]}"}
+    local IFS=$'\n'
+    COMPREPLY=($(jnx __complete $((cword - 1)) "${words[@]:1}" 2>/dev/null))
+    COMPREPLY=("${COMPREPLY[@]#"$prefix"}")
+    if [[ ${#COMPREPLY[@]} -eq 1 && ${COMPREPLY[0]} == *[/=] ]]; then
+        compopt -o nospace
+    fi
+}
+complete -F _jnx_complete jnx
+`,
+	"zsh": `#compdef jnx
+# zsh completion for jnx. Load it with:
+#   source <(jnx completion zsh)
+_jnx() {
+    local -a completions nospace space
+    completions=("${(@f)$(jnx __complete $((CURRENT - 2)) "${(@)words[2,CURRENT-1]}" "$PREFIX" "${(@)words[CURRENT+1,-1]}" 2>/dev/null)}")
+    for c in ${completions:#}; do
+        if [[ $c == */ || $c == *= ]]; then
+            nospace+=("$c")
+        else
+            space+=("$c")
+        fi
+    done
+    compadd -S '' -a nospace
+    compadd -a space
+}
+compdef _jnx jnx
+`,
+	"fish": `# fish completion for jnx. Load it with:
+#   jnx completion fish | source
+function __jnx_complete
+    set -l before (commandline -opc)
+    set -l all (commandline -o)
+    # The tokens after the cursor. The current token is one of all unless
+    # it is empty.
+    set -l skip (count $before)
+    if test -n (commandline -ct)
+        set skip (math $skip + 1)
+    end
+    set -l after
+    for i in (seq (math $skip + 1) (count $all))
+        set -a after $all[$i]
+    end
+    jnx __complete (math (count $before) - 1) $before[2..-1] (commandline -ct) $after 2>/dev/null
+end
+complete -c jnx -f -a '(__jnx_complete)'
+`,
+}
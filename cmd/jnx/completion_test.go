@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/kong"
+	"github.com/stretchr/testify/require"
+)
+
+func completionDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "jnx-completion")
+	require.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(dir, "a.jsonnet"), []byte("function(env, replicas=1) {}"), 0o600)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(dir, ".hidden"), nil, 0o600)
+	require.NoError(t, err)
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0o700))
+	return dir + string(filepath.Separator)
+}
+
+func TestCompleteArgs(t *testing.T) {
+	dir := completionDir(t)
+	defer os.RemoveAll(dir)
+	file := dir + "a.jsonnet"
+	sub := dir + "sub" + string(filepath.Separator)
+	tests := map[string]struct {
+		args  []string
+		cword int
+		want  []string
+	}{
+		"flag name":         {[]string{"--ext-str-f"}, 0, []string{"--ext-str-file"}},
+		"short flag":        {[]string{"-J"}, 0, []string{"-J"}},
+		"flag equals path":  {[]string{"--ext-str-file=x=" + dir + "a"}, 0, []string{"--ext-str-file=x=" + file}},
+		"flag equals var":   {[]string{"--ext-str=x=" + dir}, 0, nil},
+		"file flag":         {[]string{"--ext-vars-file", dir}, 1, []string{dir + "a.jsonnet", sub}},
+		"dir flag":          {[]string{"-J", dir}, 1, []string{sub}},
+		"dir flag equals":   {[]string{"--jpath=" + dir}, 0, []string{"--jpath=" + sub}},
+		"filename":          {[]string{dir}, 0, []string{file, sub}},
+		"hidden filename":   {[]string{dir + "."}, 0, []string{dir + ".hidden"}},
+		"after flag value":  {[]string{"-J", dir, dir + "a"}, 2, []string{file}},
+		"command":           {[]string{"comp"}, 0, []string{"completion", "completion.go", "completion_test.go"}},
+		"eval command":      {[]string{"eval", dir}, 1, []string{file, sub}},
+		"eval no command":   {[]string{"eval", "pro"}, 1, []string{"proxy.go"}},
+		"other command":     {[]string{"proxy", "--"}, 1, nil},
+		"dashdash":          {[]string{"--", dir + "a"}, 1, []string{file}},
+		"after filename":    {[]string{file, "x"}, 1, nil},
+		"flag after file":   {[]string{file, "-J", dir}, 2, []string{sub}},
+		"equals after file": {[]string{file, "--x", "1", "--jpath=" + dir}, 3, []string{"--jpath=" + sub}},
+		"script dashdash":   {[]string{file, "--", "-J", dir}, 3, nil},
+		"tla name":          {[]string{"-A", "e", file}, 1, []string{"env="}},
+		"tla name equals":   {[]string{"--tla-code=r", file}, 0, []string{"--tla-code=replicas="}},
+		"tla name no file":  {[]string{"-A", "e"}, 1, nil},
+		"tla value":         {[]string{"-A", "env=" + dir, file}, 1, nil},
+		"tla file value":    {[]string{"--tla-str-file", "env=" + dir + "a", file}, 1, []string{"env=" + file}},
+		"script params":     {[]string{file, "--"}, 1, []string{"--env", "--help", "--replicas"}},
+		"script param name": {[]string{"-y", file, "--r"}, 2, []string{"--replicas"}},
+		"bad index":         {[]string{"x"}, 1, nil},
+	}
+	root := kong.Must(newCLI()).Model.Node
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			got := completeArgs(root, tc.args, tc.cword)
+			if len(tc.want) == 0 {
+				require.Empty(t, got)
+			} else {
+				require.Equal(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCompleteFlagValue(t *testing.T) {
+	dir := completionDir(t)
+	defer os.RemoveAll(dir)
+	file := dir + "a.jsonnet"
+	tests := map[string]struct {
+		flag string
+		val  string
+		want []string
+	}{
+		"dir":           {"jpath", dir, []string{dir + "sub" + string(filepath.Separator)}},
+		"file":          {"tla-vars-file", dir + "a", []string{file}},
+		"var file":      {"ext-code-file", "x=" + dir + "a", []string{"x=" + file}},
+		"var secret":    {"ext-str-secret", "x=" + dir + "a", []string{"x=" + file}},
+		"var str":       {"ext-str", "x=" + dir, nil},
+		"ext var name":  {"ext-str", "e", nil},
+		"tla var name":  {"tla-str", "", []string{"env=", "replicas="}},
+		"tla code name": {"tla-code-file", "", []string{"env=", "replicas="}},
+		"other":         {"ext-str-env-prefix", dir, nil},
+	}
+	root := kong.Must(newCLI()).Model.Node
+	flags := map[string]*kong.Flag{}
+	for _, f := range evalFlags(root) {
+		flags[f.Name] = f
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			f := flags[tc.flag]
+			require.NotNil(t, f)
+			got := completeFlagValue(f, tc.val, file)
+			if len(tc.want) == 0 {
+				require.Empty(t, got)
+			} else {
+				require.ElementsMatch(t, tc.want, got)
+			}
+		})
+	}
+}
@@ -1,15 +1,37 @@
-// jnx evaluates a jsonnet file and outputs it as JSON.
+// jnx evaluates a jsonnet file and outputs it as JSON, YAML or a string.
 //
-// Usage: jnx [<filename>]
+// Usage: jnx <command>
+//
+// Commands:
+//   eval [<filename>]
+//     Evaluate a jsonnet file (the default command)
+//
+//   proxy
+//     Run a caching HTTP proxy server for netpath imports
+//
+//   completion <shell>
+//     Write a shell completion script for jnx to stdout
+//
+// Eval
+//
+// eval is the default command, so "jnx [<flags>] [<filename>]" evaluates a
+// file. A file named like a command, such as proxy, is evaluated with "jnx
+// eval proxy" or "jnx ./proxy".
+//
+// Usage: jnx eval [<filename>]
 //
 // Arguments:
 //   [<filename>]    File to evaluate. stdin is used if omitted or "-"
 //
 // Flags:
 //   -h, --help                            Show context-sensitive help.
-//   -J, --jpath=dir                       Add a library search dir
-//       --max-stack=500                   Number of allowed stack frames of jsonnet VM
-//       --max-trace=20                    Maximum number of stack frames output on error
+//   -J, --jpath=dir                       Add a library search dir ($JNX_JPATH)
+//       --max-stack=500                   Number of allowed stack frames of jsonnet VM ($JNX_MAX_STACK)
+//       --max-trace=20                    Maximum number of stack frames output on error ($JNX_MAX_TRACE)
+//       --timeout=DURATION                Maximum time to evaluate for, such as 30s (no limit if 0) ($JNX_TIMEOUT)
+//       --max-output=INT                  Maximum size of the output in bytes (no limit if 0) ($JNX_MAX_OUTPUT)
+//       --max-imports=INT                 Maximum number of files imported (no limit if 0) ($JNX_MAX_IMPORTS)
+//       --max-import-bytes=INT            Maximum total size of files imported in bytes (no limit if 0) ($JNX_MAX_IMPORT_BYTES)
 //   -V, --ext-str=var[=str]               Set extVar string (str from env if omitted)
 //       --ext-str-file=var[=filename]     Set extVar string from a file (filename from env if omitted)
 //       --ext-code=var[=code]             Set extVar code (code from env if omitted)
@@ -18,4 +40,81 @@
 //       --tla-str-file=var[=filename]     Set top-level arg string from a file (filename from env if omitted)
 //       --tla-code=var[=code]             Set top-level arg code (code from env if omitted)
 //       --tla-code-file=var[=filename]    Set top-level arg code from a file (filename from env if omitted)
+//       --ext-vars-file=filename          Set extVars from the fields of a JSON or YAML file
+//       --tla-vars-file=filename          Set top-level args from the fields of a JSON or YAML file
+//       --ext-str-env-prefix=prefix       Set extVar strings from env vars starting with prefix, with it removed
+//       --ext-str-secret=var[=file]       Set secret extVar string from a file or fd:N (from env if omitted)
+//       --ext-code-secret=var[=file]      Set secret extVar code from a file or fd:N (from env if omitted)
+//       --tla-str-secret=var[=file]       Set secret top-level arg string from a file or fd:N (from env if omitted)
+//       --tla-code-secret=var[=file]      Set secret top-level arg code from a file or fd:N (from env if omitted)
+//       --ext-str-cmd=var=command         Set extVar string from the output of a command
+//       --tla-str-cmd=var=command         Set top-level arg string from the output of a command
+//   -O, --ext-obj=var.field=value         Set field of extVar object (quote value to force a string)
+//   -S, --string                          Expect a string result and output it as is
+//       --yaml                            Output YAML instead of JSON
+//   -y, --yaml-stream                     Output the elements of an array result as a stream of YAML documents
+//   -m, --multi=dir                       Write each field of an object result to a JSON file in dir
+//       --profile=STRING                  Select a profile from the project config file
+//
+// Defaults for the flags are taken from JNX_ environment variables and then
+// from the project config file (.jnx.jsonnet or jnx.yaml) found in the current
+// directory or its parents. Values on the command line take precedence over
+// the environment, which takes precedence over the config file. The
+// environment variables are named after the flags: JNX_JPATH, JNX_MAX_STACK,
+// JNX_TIMEOUT, JNX_MAX_IMPORT_BYTES and so on, and JNX_EXT_STR_<var>,
+// JNX_TLA_CODE_FILE_<var> and so on for each of the var flags.
+//
+// If the file evaluates to a function, the top-level args are checked against
+// its parameters before evaluation. Unknown args, with a suggestion for likely
+// typos, and missing required parameters are reported as errors.
+//
+// Scripts
+//
+// Arguments after the filename are passed to the top-level function of the
+// file as top-level args, so a jsonnet file can be run as a command with a
+// shebang line:
+//
+//   #!/usr/bin/env -S jnx -y
+//   function(env, replicas=1) { ... }
+//
+// Run as "./deploy.jsonnet --env prod --replicas 3", each "--param value" or
+// "--param=value" sets the top-level arg param. Values that are JSON numbers,
+// booleans or null are passed as such and other values as strings; quote a
+// value to always pass it as a string. A parameter with a boolean default can
+// be given without a value to set it to true. "--help" after the filename
+// lists the parameters and their defaults.
+//
+// The jnx flags, such as -J or --ext-str, are still jnx flags after the
+// filename, so "jnx file.jsonnet -J lib" adds an import path whether the file
+// is a script or not. Arguments after a "--" following the filename are all
+// script args, for parameters named like a jnx flag.
+//
+// Proxy
+//
+// "jnx proxy" runs a caching HTTP proxy server for netpath imports. Netpath
+// content is fetched on demand and cached on disk. Point the Fetcher of a
+// jsonnext.Importer at it with a proxy.Fetcher.
+//
+// Usage: jnx proxy
+//
+// Flags:
+//   -h, --help              Show context-sensitive help.
+//       --listen=":8080"    Address to listen on
+//       --cache-dir=dir     Directory to cache fetched files in (default: user cache dir)
+//
+// The proxy serves cache statistics as JSON at GET /_stats and removes all
+// cached content on POST /_purge.
+//
+// Completion
+//
+// "jnx completion <shell>" writes a completion script for bash, zsh or fish
+// to stdout. Load it with "source <(jnx completion bash)", "source <(jnx
+// completion zsh)" or "jnx completion fish | source". The scripts complete
+// flag names, file paths for the filename and the values of the file flags
+// such as --ext-str-file, and directories for -J. The TLA flags, such as
+// --tla-str, complete the names of the parameters of the top-level function
+// of the file on the command line, which are also completed as script args
+// after the filename.
+//
+// Usage: jnx completion <shell>
 package main
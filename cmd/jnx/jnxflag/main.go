@@ -1,33 +1,87 @@
-// jnxflag evaluates a jsonnet file and outputs it as JSON.
+// jnxflag evaluates a jsonnet file and outputs it as JSON, YAML or a string.
 //
 // Usage of ./jnxflag:
 //   -A var[=str]
 //         Add top-level arg var[=str] (from environment if <str> is omitted)
 //   -J dir
 //         Add a library search dir
+//   -O var.field=value
+//         Set field of extVar object var.field=value (quote value to force a string)
+//   -S    Expect a string result and output it as is
 //   -V var[=str]
 //         Add extVar var[=str] (from environment if <str> is omitted)
 //   -ext-code var[=code]
 //         Add extVar var[=code] (from environment if <code> is omitted)
 //   -ext-code-file var=file
 //         Add extVar var=file code from a file
+//   -ext-code-secret var[=file]
+//         Add secret extVar var[=file] code from a file or fd:N (from environment if <file> is omitted)
+//   -ext-obj var.field=value
+//         Set field of extVar object var.field=value (quote value to force a string)
 //   -ext-str var[=str]
 //         Add extVar var[=str] (from environment if <str> is omitted)
+//   -ext-str-cmd var=command
+//         Add extVar var=command string from the output of a command
+//   -ext-str-env-prefix prefix
+//         Add extVar strings from environment variables starting with prefix, with it removed
 //   -ext-str-file var=file
 //         Add extVar var=file string from a file
+//   -ext-str-secret var[=file]
+//         Add secret extVar var[=file] string from a file or fd:N (from environment if <file> is omitted)
+//   -ext-vars-file file
+//         Add extVars from the fields of a JSON or YAML file
 //   -jpath dir
 //         Add a library search dir
+//   -m dir
+//         Write each field of an object result to a JSON file in dir
+//   -max-import-bytes value
+//         Maximum total size of files imported in bytes (no limit if 0)
+//   -max-imports value
+//         Maximum number of files imported (no limit if 0)
+//   -max-output value
+//         Maximum size of the output in bytes (no limit if 0)
+//   -max-stack value
+//         Number of allowed stack frames of jsonnet VM (default 500)
+//   -max-trace value
+//         Maximum number of stack frames output on error (default 20)
+//   -multi dir
+//         Write each field of an object result to a JSON file in dir
+//   -profile profile
+//         Select a profile from the project config file
+//   -string
+//         Expect a string result and output it as is
+//   -timeout value
+//         Maximum time to evaluate for, such as 30s (no limit if 0)
 //   -tla-code var[=code]
 //         Add top-level arg var[=code] (from environment if <code> is omitted)
 //   -tla-code-file var=file
 //         Add top-level arg var=file code from a file
+//   -tla-code-secret var[=file]
+//         Add secret top-level arg var[=file] code from a file or fd:N (from environment if <file> is omitted)
 //   -tla-str var=[=str]
 //         Add top-level arg var=[=str] (from environment if <str> is omitted)
+//   -tla-str-cmd var=command
+//         Add top-level arg var=command string from the output of a command
 //   -tla-str-file var=file
 //         Add top-level arg var=file string from a file
+//   -tla-str-secret var[=file]
+//         Add secret top-level arg var[=file] string from a file or fd:N (from environment if <file> is omitted)
+//   -tla-vars-file file
+//         Add top-level args from the fields of a JSON or YAML file
+//   -y    Output the elements of an array result as a stream of YAML documents
+//   -yaml
+//         Output YAML instead of JSON
+//   -yaml-stream
+//         Output the elements of an array result as a stream of YAML documents
+//
+// Defaults for the flags are taken from JNX_ environment variables, such as
+// JNX_MAX_STACK or JNX_EXT_STR_<var>, and then from the project config file
+// (.jnx.jsonnet or jnx.yaml) found in the current directory or its parents.
 //
 // This program exists just to implement the standard Go flag package parsing.
-// The full jnx program uses the kong library and has more features.
+// The full jnx program uses the kong library and has more features. Unlike
+// jnx, it does not run jsonnet files as scripts: arguments after the filename
+// are an error rather than top-level args.
 
 package main
 
@@ -37,52 +91,65 @@ import (
 	"os"
 
 	"foxygo.at/jsonnext"
-	jsonnet "github.com/google/go-jsonnet"
 )
 
 type config struct {
 	jsonnext.Config
+	jsonnext.OutputFlags
+	Profile  string
 	Filename string `arg:"" optional:"" help:"File to evaluate. stdin is used if omitted or \"-\""`
 }
 
 func main() {
 	cli := parseCLI()
-	vm := cli.Config.MakeVM("JNXPATH")
-
-	out, err := run(vm, cli.Filename)
-	if err != nil {
+	if err := cli.Run(&cli.Config, "JNXPATH", cli.Filename, os.Stdout); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
-	fmt.Print(out)
 }
 
 // Parse CLI using Go's flag package and the helpers in jsonnext.
 func parseCLI() *config {
-	c := &config{}
-	c.Config = *jsonnext.ConfigFlags(flag.CommandLine)
-
+	c := configFlags(flag.CommandLine)
 	flag.Parse()
 	if flag.NArg() > 1 {
+		fmt.Fprintln(os.Stderr, "arguments after the filename are not supported, use jnx to run a script")
 		flag.Usage()
 		os.Exit(1)
 	} else if flag.NArg() == 1 {
 		c.Filename = flag.Args()[0]
 	}
 
-	return c
-}
-
-func run(vm *jsonnet.VM, filename string) (string, error) {
-	node, _, err := vm.ImportAST("", filename)
-	if err != nil {
-		return "", err
+	if err := c.Config.LoadEnv(jsonnext.EnvPrefix); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-
-	out, err := vm.Evaluate(node)
-	if err != nil {
-		return "", err
+	if err := c.Config.LoadProjectConfig(".", c.Profile); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := jsonnext.PrintWarnings(os.Stderr, c.Config.CheckWithEnv("JNXPATH")); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
-	return out, nil
+	return c
+}
+
+// configFlags defines the flags of jnxflag in fs and returns the config they
+// populate. The Config is bound to the flags in place rather than copied from
+// the one returned by jsonnext.ConfigFlags, so that the flags of its scalar
+// fields set it.
+func configFlags(fs *flag.FlagSet) *config {
+	c := &config{Config: *jsonnext.NewConfig()}
+	jsonnext.ConfigFlagsVar(fs, &c.Config)
+	fs.StringVar(&c.Profile, "profile", "", "Select a `profile` from the project config file")
+	fs.BoolVar(&c.String, "S", false, "Expect a string result and output it as is")
+	fs.BoolVar(&c.String, "string", false, "Expect a string result and output it as is")
+	fs.BoolVar(&c.YAML, "yaml", false, "Output YAML instead of JSON")
+	fs.BoolVar(&c.YAMLStream, "y", false, "Output the elements of an array result as a stream of YAML documents")
+	fs.BoolVar(&c.YAMLStream, "yaml-stream", false, "Output the elements of an array result as a stream of YAML documents")
+	fs.StringVar(&c.Multi, "m", "", "Write each field of an object result to a JSON file in `dir`")
+	fs.StringVar(&c.Multi, "multi", "", "Write each field of an object result to a JSON file in `dir`")
+	return c
 }
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"foxygo.at/jsonnext"
+	"foxygo.at/s/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigFlagsTimeout(t *testing.T) {
+	test.Env.Set("JNX_TIMEOUT", "5s")
+	test.Env.Set("JNX_MAX_STACK", "100")
+	defer test.Env.Restore()
+
+	fs := flag.NewFlagSet("jnxflag", flag.ContinueOnError)
+	c := configFlags(fs)
+	err := fs.Parse([]string{"-timeout", "1s", "-J", "lib", "file.jsonnet"})
+	require.NoError(t, err)
+	require.NoError(t, c.Config.LoadEnv(jsonnext.EnvPrefix))
+
+	require.Equal(t, time.Second, c.Config.Timeout)
+	require.Equal(t, []string{"lib"}, c.Config.ImportPath)
+	require.Equal(t, 100, c.Config.MaxStack)
+	require.Equal(t, []string{"file.jsonnet"}, fs.Args())
+}
+
+func TestConfigFlagsLimits(t *testing.T) {
+	test.Env.Set("JNX_MAX_OUTPUT", "1")
+	defer test.Env.Restore()
+
+	fs := flag.NewFlagSet("jnxflag", flag.ContinueOnError)
+	c := configFlags(fs)
+	err := fs.Parse([]string{"-max-output", "100", "-max-imports", "10", "-max-import-bytes", "1000"})
+	require.NoError(t, err)
+	require.NoError(t, c.Config.LoadEnv(jsonnext.EnvPrefix))
+
+	require.Equal(t, 100, c.Config.MaxOutput)
+	require.Equal(t, 10, c.Config.MaxImports)
+	require.Equal(t, 1000, c.Config.MaxImportBytes)
+	e := jsonnext.NewEvaluator(&c.Config, "", jsonnext.OutputJSON)
+	require.Equal(t, 100, e.MaxOutput)
+	require.Equal(t, 10, e.Importer.MaxImports)
+	require.Equal(t, 1000, e.Importer.MaxImportBytes)
+}
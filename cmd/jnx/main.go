@@ -1,42 +1,93 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
+	"foxygo.at/jsonnext"
 	jnxkong "foxygo.at/jsonnext/kong"
 	"github.com/alecthomas/kong"
-	jsonnet "github.com/google/go-jsonnet"
 )
 
-type config struct {
-	jnxkong.Config
+type cli struct {
+	Eval       evalCmd       `cmd:"" default:"withargs" help:"Evaluate a jsonnet file (the default command)"`
+	Proxy      proxyCmd      `cmd:"" help:"Run a caching HTTP proxy server for netpath imports"`
+	Completion completionCmd `cmd:"" help:"Write a shell completion script for jnx to stdout"`
+	Complete   completeCmd   `cmd:"" name:"__complete" hidden:"" passthrough:"" help:"Write the completions for the words of a command line"`
+}
+
+// evalCmd embeds the jsonnext flags in a named field, as kong would take the
+// UnmarshalJSON method promoted by an anonymous jnxkong.Config to mean that
+// the command is a flag.
+type evalCmd struct {
+	Config jnxkong.Config `embed:""`
+	jsonnext.OutputFlags
+	Profile  string `help:"Select a profile from the project config file"`
 	Filename string `arg:"" optional:"" help:"File to evaluate. stdin is used if omitted or \"-\""`
+
+	// scriptArgs are the arguments after the filename, for the top-level
+	// function of the file. See splitScriptArgs.
+	scriptArgs []string
 }
 
-func main() {
-	c := &config{Config: *jnxkong.NewConfig()}
-	kong.Parse(c)
-	vm := c.Config.MakeVM("JNXPATH")
+func newCLI() *cli {
+	return &cli{Eval: evalCmd{Config: *jnxkong.NewConfig()}}
+}
 
-	out, err := run(vm, c.Filename)
+func main() {
+	c := newCLI()
+	parser := kong.Must(c, kong.Description("Evaluate jsonnet files, or run one of the jnx commands."))
+	// Arguments after the filename are for the top-level function of the
+	// file, so a jsonnet file can be run as a script with a shebang line.
+	args, scriptArgs := splitScriptArgs(parser.Model.Node, os.Args[1:])
+	ctx, err := parser.Parse(args)
+	parser.FatalIfErrorf(err)
+	c.Eval.scriptArgs = scriptArgs
+	err = ctx.Run()
+	if errors.Is(err, errScriptHelp) {
+		parser.Exit(0)
+		return
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
-	fmt.Print(out)
 }
 
-func run(vm *jsonnet.VM, filename string) (string, error) {
-	node, _, err := vm.ImportAST("", filename)
-	if err != nil {
-		return "", err
+// AfterApply fills the Config from the environment, as kong does not call the
+// hooks of embedded fields.
+func (c *evalCmd) AfterApply(ctx *kong.Context) error {
+	return c.Config.AfterApply(ctx)
+}
+
+// Run evaluates the file of the eval command. Problems with the config are
+// fatal errors of ctx and evaluation errors are returned.
+func (c *evalCmd) Run(ctx *kong.Context) error {
+	if len(c.scriptArgs) > 0 {
+		err := setScriptArgs(os.Stdout, c.Config.Config, c.Filename, c.scriptArgs)
+		if errors.Is(err, errScriptHelp) {
+			return err
+		}
+		ctx.FatalIfErrorf(err)
 	}
+	ctx.FatalIfErrorf(c.Config.LoadProjectConfig(".", c.Profile))
+	ctx.FatalIfErrorf(jsonnext.PrintWarnings(os.Stderr, c.Config.CheckWithEnv("JNXPATH")))
+	ctx.FatalIfErrorf(checkTLAs(c.Config.Config, c.Filename))
+	return c.OutputFlags.Run(c.Config.Config, "JNXPATH", c.Filename, os.Stdout)
+}
 
-	out, err := vm.Evaluate(node)
+// checkTLAs checks the TLAs in cfg against the parameters of the top-level
+// function of filename, so that unknown and missing TLAs are reported with
+// the parameter names before evaluation. Files that cannot be parsed or do not
+// have a top-level function are left for evaluation to report.
+func checkTLAs(cfg *jsonnext.Config, filename string) error {
+	if filename == "" || filename == "-" {
+		return nil
+	}
+	params, err := jsonnext.FileParams(filename)
 	if err != nil {
-		return "", err
+		return nil
 	}
-
-	return out, nil
+	return cfg.CheckTLAs(params).Err()
 }
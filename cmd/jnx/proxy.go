@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"foxygo.at/jsonnext"
+	"foxygo.at/jsonnext/proxy"
+)
+
+const proxyTimeout = 30 * time.Second
+
+type proxyCmd struct {
+	Listen   string `default:":8080" help:"Address to listen on"`
+	CacheDir string `type:"path" placeholder:"dir" help:"Directory to cache fetched files in (default: user cache dir)"`
+}
+
+// Run runs a netpath proxy server until it fails.
+func (c *proxyCmd) Run() error {
+	if c.CacheDir == "" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return err
+		}
+		c.CacheDir = filepath.Join(dir, "jnx", "proxy")
+	}
+	if err := os.MkdirAll(c.CacheDir, 0o755); err != nil { //nolint:gomnd
+		return err
+	}
+
+	srv := &http.Server{
+		Addr:         c.Listen,
+		Handler:      proxy.NewServer(c.CacheDir, &jsonnext.Importer{}),
+		ReadTimeout:  proxyTimeout,
+		WriteTimeout: proxyTimeout,
+	}
+	return srv.ListenAndServe()
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"foxygo.at/jsonnext"
+	"github.com/alecthomas/kong"
+)
+
+// splitScriptArgs splits args into the arguments for jnx and the arguments
+// after the filename that are for the top-level function of the file when it
+// is run as a script. root is the kong model of jnx, whose eval command flags
+// are used to skip the values of flags that take one, so that they are not
+// taken as the filename. args for a command other than eval are not split.
+//
+// The flags of the eval command after the filename, other than -h and
+// --help, are still for jnx, so "jnx file.jsonnet -J lib" works as it does
+// without script args. They are moved before the filename in jnxArgs. All
+// arguments after a "--" following the filename are script args, so that a
+// parameter named like a jnx flag can be given after it.
+func splitScriptArgs(root *kong.Node, args []string) (jnxArgs, scriptArgs []string) {
+	flags := map[string]*kong.Flag{}
+	for _, f := range evalFlags(root) {
+		flags["--"+f.Name] = f
+		if f.Short != 0 {
+			flags["-"+string(f.Short)] = f
+		}
+	}
+	cmdSeen := false
+	dashdash := -1
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--" && dashdash < 0:
+			dashdash = i
+		case dashdash < 0 && strings.HasPrefix(arg, "-") && arg != "-":
+			if f := flags[arg]; f != nil && !f.IsBool() {
+				i++
+			}
+		case dashdash < 0 && !cmdSeen && arg == root.DefaultCmd.Name:
+			cmdSeen = true
+		case dashdash < 0 && !cmdSeen && command(root, arg) != nil:
+			return args, nil
+		default:
+			interspersed, scriptArgs := splitAfterFile(flags, args[i+1:])
+			at := i
+			if dashdash >= 0 {
+				at = dashdash
+			}
+			jnxArgs = append(jnxArgs, args[:at]...)
+			jnxArgs = append(jnxArgs, interspersed...)
+			return append(jnxArgs, args[at:i+1]...), scriptArgs
+		}
+	}
+	return args, nil
+}
+
+// splitAfterFile splits args, the arguments after the filename, into the jnx
+// flags in flags with their values and the script args, as described for
+// splitScriptArgs.
+func splitAfterFile(flags map[string]*kong.Flag, args []string) (jnxArgs, scriptArgs []string) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name := strings.SplitN(arg, "=", 2)[0]
+		f := flags[name]
+		switch {
+		case arg == "--":
+			return jnxArgs, append(scriptArgs, args[i+1:]...)
+		case f == nil || f.Name == "help":
+			scriptArgs = append(scriptArgs, arg)
+		case !f.IsBool() && name == arg && i+1 < len(args):
+			jnxArgs = append(jnxArgs, arg, args[i+1])
+			i++
+		default:
+			jnxArgs = append(jnxArgs, arg)
+		}
+	}
+	return jnxArgs, scriptArgs
+}
+
+// evalFlags returns the flags of the eval command, the default command of
+// root, including those of root itself such as --help.
+func evalFlags(root *kong.Node) []*kong.Flag {
+	var flags []*kong.Flag
+	for _, group := range root.DefaultCmd.AllFlags(false) {
+		flags = append(flags, group...)
+	}
+	return flags
+}
+
+// command returns the command of root with the given name, or nil if there
+// is none.
+func command(root *kong.Node, name string) *kong.Node {
+	for _, child := range root.Children {
+		if child.Type == kong.CommandNode && child.Name == name {
+			return child
+		}
+	}
+	return nil
+}
+
+// errScriptHelp is returned by setScriptArgs when the script args ask for
+// help, once the help has been written.
+var errScriptHelp = errors.New("script help requested")
+
+// setScriptArgs sets the TLAs in cfg from args, the arguments after filename
+// on the command line, as "--param value" flags for the parameters of the
+// top-level function of filename. If args asks for help, the parameters are
+// written to w and errScriptHelp is returned without setting any TLAs.
+func setScriptArgs(w io.Writer, cfg *jsonnext.Config, filename string, args []string) error {
+	if filename == "" || filename == "-" {
+		return errors.New("arguments after the filename need a file with a top-level function")
+	}
+	params, err := jsonnext.FileParams(filename)
+	if err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if arg == "-h" || arg == "--help" {
+			printScriptHelp(w, filename, params)
+			return errScriptHelp
+		}
+	}
+	return cfg.TLAVars.SetParamArgs(params, args)
+}
+
+// printScriptHelp writes the usage of filename run as a script, listing the
+// parameters of its top-level function as flags with their defaults.
+func printScriptHelp(w io.Writer, filename string, params []jsonnext.Param) {
+	fmt.Fprintf(w, "Usage: %s [<args>]\n\n", filename)
+	if len(params) == 0 {
+		fmt.Fprintln(w, "The top-level function has no parameters.")
+		return
+	}
+	fmt.Fprintln(w, "Args:")
+	tw := tabwriter.NewWriter(w, 0, 4, 4, ' ', 0)
+	for _, p := range params {
+		if p.Required() {
+			fmt.Fprintf(tw, "  --%s=VALUE\t(required)\n", p.Name)
+		} else {
+			fmt.Fprintf(tw, "  --%s=VALUE\t(default: %s)\n", p.Name, p.Default)
+		}
+	}
+	tw.Flush() //nolint:errcheck,gosec // Help output errors are not actionable.
+	fmt.Fprintln(w, "\nValues that are JSON numbers, booleans or null are passed as such and")
+	fmt.Fprintln(w, "other values as strings. Quote a value to always pass it as a string.")
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"foxygo.at/jsonnext"
+	"github.com/alecthomas/kong"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitScriptArgs(t *testing.T) {
+	tests := map[string]struct {
+		args       []string
+		jnxArgs    []string
+		scriptArgs []string
+	}{
+		"none":              {nil, nil, nil},
+		"file":              {[]string{"file"}, []string{"file"}, nil},
+		"flags only":        {[]string{"-y", "--max-stack", "10"}, []string{"-y", "--max-stack", "10"}, nil},
+		"script args":       {[]string{"file", "--x", "1"}, []string{"file"}, []string{"--x", "1"}},
+		"dir value":         {[]string{"-J", "dir", "file", "--x"}, []string{"-J", "dir", "file"}, []string{"--x"}},
+		"long value":        {[]string{"--jpath", "dir", "file", "--x"}, []string{"--jpath", "dir", "file"}, []string{"--x"}},
+		"equals value":      {[]string{"--jpath=dir", "file", "--x"}, []string{"--jpath=dir", "file"}, []string{"--x"}},
+		"bool flag":         {[]string{"-S", "file", "--x"}, []string{"-S", "file"}, []string{"--x"}},
+		"flag after file":   {[]string{"file", "-J", "dir"}, []string{"-J", "dir", "file"}, nil},
+		"flags after file":  {[]string{"-y", "file", "--x", "1", "--ext-str=a=b", "-S", "--max-stack", "9", "--y"}, []string{"-y", "--ext-str=a=b", "-S", "--max-stack", "9", "file"}, []string{"--x", "1", "--y"}},
+		"help after file":   {[]string{"file", "-J", "dir", "--help"}, []string{"-J", "dir", "file"}, []string{"--help"}},
+		"dashdash after":    {[]string{"file", "-J", "dir", "--", "-J", "--yaml"}, []string{"-J", "dir", "file"}, []string{"-J", "--yaml"}},
+		"dashdash flags":    {[]string{"--", "-file", "-S", "--x"}, []string{"-S", "--", "-file"}, []string{"--x"}},
+		"eval flag after":   {[]string{"eval", "file", "-J", "dir"}, []string{"eval", "-J", "dir", "file"}, nil},
+		"stdin":             {[]string{"-", "--x"}, []string{"-"}, []string{"--x"}},
+		"dashdash":          {[]string{"-y", "--", "-file", "--x"}, []string{"-y", "--", "-file"}, []string{"--x"}},
+		"trailing dashdash": {[]string{"-y", "--"}, []string{"-y", "--"}, nil},
+		"missing value":     {[]string{"-J"}, []string{"-J"}, nil},
+		"eval command":      {[]string{"eval", "-y", "proxy", "--x"}, []string{"eval", "-y", "proxy"}, []string{"--x"}},
+		"other command":     {[]string{"completion", "bash", "--x"}, []string{"completion", "bash", "--x"}, nil},
+		"proxy command":     {[]string{"proxy", "--listen", ":80"}, []string{"proxy", "--listen", ":80"}, nil},
+		"complete command":  {[]string{"__complete", "file", "--x"}, []string{"__complete", "file", "--x"}, nil},
+	}
+	root := kong.Must(newCLI()).Model.Node
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			jnxArgs, scriptArgs := splitScriptArgs(root, tc.args)
+			require.Equal(t, tc.jnxArgs, jnxArgs)
+			if len(tc.scriptArgs) == 0 {
+				require.Empty(t, scriptArgs)
+			} else {
+				require.Equal(t, tc.scriptArgs, scriptArgs)
+			}
+		})
+	}
+}
+
+func TestSetScriptArgsNoFile(t *testing.T) {
+	var buf bytes.Buffer
+	for _, filename := range []string{"", "-"} {
+		err := setScriptArgs(&buf, jsonnext.NewConfig(), filename, []string{"--help"})
+		require.Error(t, err)
+		require.NotEqual(t, errScriptHelp, err)
+	}
+	require.Equal(t, "", buf.String())
+}
@@ -0,0 +1,86 @@
+package jsonnext
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewStrCmd(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("commands use sh")
+	}
+	v, err := NewExtStrCmd("echo '  v1.2 '")
+	require.NoError(t, err)
+	require.Equal(t, NewExtStr("v1.2"), v)
+	v, err = NewTLAStrCmd("printf 'a\\nb\\n'")
+	require.NoError(t, err)
+	require.Equal(t, NewTLAStr("a\nb"), v)
+
+	_, err = NewExtStrCmd("echo oops >&2; exit 3")
+	var cerr *CommandError
+	require.True(t, errors.As(err, &cerr), "%v", err)
+	require.Equal(t, "oops", cerr.Stderr)
+	var exitErr *exec.ExitError
+	require.True(t, errors.As(err, &exitErr))
+	require.Equal(t, 3, exitErr.ExitCode())
+	require.Equal(t, `command "echo oops >&2; exit 3": exit status 3: oops`, err.Error())
+
+	_, err = runCommand("exec sleep 5", 50*time.Millisecond)
+	require.True(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+	require.Contains(t, err.Error(), "timed out after 50ms")
+
+	// The shell forks sleep rather than exec it. The timeout must kill
+	// sleep too, as it holds the output of the shell open.
+	start := time.Now()
+	_, err = runCommand("sleep 5; echo done", 50*time.Millisecond)
+	require.True(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+	require.Less(t, int64(time.Since(start)), int64(2*time.Second))
+}
+
+func TestSetCmdVar(t *testing.T) {
+	m := VMVarMap{}
+	require.True(t, errors.Is(m.SetCmdVar("=true", NewExtStrCmd), ErrMissingKey))
+	require.True(t, errors.Is(m.SetCmdVar("var", NewExtStrCmd), ErrMissingValue))
+	require.True(t, errors.Is(m.SetCmdVar("var= ", NewExtStrCmd), ErrMissingValue))
+	require.Empty(t, m)
+}
+
+func TestNoCommands(t *testing.T) {
+	fs := &flag.FlagSet{}
+	c := ConfigFlags(fs)
+	c.NoCommands = true
+	err := fs.Parse([]string{"-ext-str-cmd", "sha=git rev-parse HEAD"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), ErrCommandsDisabled.Error()) // flag does not wrap errors
+	require.Empty(t, c.ExtVars)
+
+	// The Config methods check NoCommands for library callers too.
+	c = &Config{NoCommands: true}
+	require.True(t, errors.Is(c.SetExtStrCmd("sha=git rev-parse HEAD"), ErrCommandsDisabled))
+	require.True(t, errors.Is(c.SetTLAStrCmd("sha=git rev-parse HEAD"), ErrCommandsDisabled))
+	require.Empty(t, c.ExtVars)
+	require.Empty(t, c.TLAVars)
+}
+
+func TestConfigSetStrCmd(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("commands use sh")
+	}
+	c := &Config{}
+	require.NoError(t, c.SetExtStrCmd("v=echo 1.2"))
+	require.NoError(t, c.SetTLAStrCmd("w=echo 3"))
+	require.Equal(t, VMVarMap{"v": NewExtStr("1.2")}, c.ExtVars)
+	require.Equal(t, VMVarMap{"w": NewTLAStr("3")}, c.TLAVars)
+
+	c.CommandTimeout = 50 * time.Millisecond
+	err := c.SetExtStrCmd("x=exec sleep 5")
+	require.True(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+	require.Contains(t, err.Error(), "timed out after 50ms")
+}
@@ -1,12 +1,17 @@
 package jsonnext
 
 import (
+	"encoding/json"
 	"errors"
+	"io/ioutil"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"foxygo.at/s/errs"
 	jsonnet "github.com/google/go-jsonnet"
+	"gopkg.in/yaml.v3"
 )
 
 const (
@@ -19,6 +24,7 @@ const (
 var (
 	ErrMissingKey   = errors.New("missing key")
 	ErrMissingValue = errors.New("missing value")
+	ErrInvalidValue = errors.New("invalid value")
 )
 
 // Config holds configuration for a jsonnet VM and the Importer defined in this
@@ -27,11 +33,30 @@ var (
 // VM. This package provides two options for populating it from the command line
 // (Go flags or Kong).
 type Config struct {
-	ImportPath []string `name:"jpath" sep:"none" short:"J" placeholder:"dir" help:"Add a library search dir"`
-	ExtVars    VMVarMap `kong:"-"`
-	TLAVars    VMVarMap `kong:"-"`
-	MaxStack   int      `default:"500" help:"Number of allowed stack frames of jsonnet VM"`
-	MaxTrace   int      `default:"20" help:"Maximum number of stack frames output on error"`
+	ImportPath     []string                  `kong:"-"`
+	ExtVars        VMVarMap                  `kong:"-"`
+	TLAVars        VMVarMap                  `kong:"-"`
+	MaxStack       int                       `default:"500" env:"JNX_MAX_STACK" help:"Number of allowed stack frames of jsonnet VM"`
+	MaxTrace       int                       `default:"20" env:"JNX_MAX_TRACE" help:"Maximum number of stack frames output on error"`
+	Timeout        time.Duration             `env:"JNX_TIMEOUT" help:"Maximum time to evaluate for, such as 30s (no limit if 0)"`
+	MaxOutput      int                       `env:"JNX_MAX_OUTPUT" help:"Maximum size of the output in bytes (no limit if 0)"`
+	MaxImports     int                       `env:"JNX_MAX_IMPORTS" help:"Maximum number of files imported (no limit if 0)"`
+	MaxImportBytes int                       `env:"JNX_MAX_IMPORT_BYTES" help:"Maximum total size of files imported in bytes (no limit if 0)"`
+	Natives        []*jsonnet.NativeFunction `kong:"-"`
+
+	// NoCommands disables setting vars from the output of commands with
+	// the --ext-str-cmd and --tla-str-cmd flags, for embedders that do not
+	// want their users to run commands.
+	NoCommands bool `kong:"-"`
+
+	// CommandTimeout is the maximum time a command run for a var by
+	// SetExtStrCmd or SetTLAStrCmd may take. If zero, the CommandTimeout
+	// constant is used.
+	CommandTimeout time.Duration `kong:"-"`
+
+	// set holds the names of the flags of the scalar fields that have
+	// been set explicitly. See MarkSet.
+	set map[string]bool
 }
 
 // NewConfig returns a new initialised but empty Config struct.
@@ -44,38 +69,153 @@ func NewConfig() *Config {
 	}
 }
 
+// MarkSet records that the scalar fields of c set by the flags with the given
+// names, such as "max-stack" or "timeout", have been set explicitly, so that
+// LoadEnv and ConfigFile.Apply do not replace them even if they are set to
+// their default value. The flags defined in this module and LoadEnv mark the
+// fields they set. A field that does not have its default value is treated
+// as set whether it has been marked or not, so an application populating a
+// Config directly only needs to mark the fields it sets to their default.
+func (c *Config) MarkSet(names ...string) {
+	if c.set == nil {
+		c.set = map[string]bool{}
+	}
+	for _, name := range names {
+		c.set[name] = true
+	}
+}
+
+// isSet returns true if the field of c set by the flag name has been marked
+// as set, or if it does not have its default value as given by isDefault.
+func (c *Config) isSet(name string, isDefault bool) bool {
+	return !isDefault || c.set[name]
+}
+
+// fillInt sets the int field *p of c, set by the flag name and with the
+// default value def, to v and marks it as set, unless it is already set.
+func (c *Config) fillInt(p *int, name string, def, v int) {
+	if !c.isSet(name, *p == def) {
+		*p = v
+		c.MarkSet(name)
+	}
+}
+
 // MakeVM returns a jsonnet.VM configured with the external vars and top-level
 // args in Config, and sets its Importer to be a jsonnext.Importer also
 // configured from Config. The importer search path is extended with elements
 // from the environment variable pathEnvVar. pathEnvVar may be the empty string
 // if no environment variable should be used.
 func (c *Config) MakeVM(pathEnvVar string) *jsonnet.VM {
+	vm, _ := c.makeVM(pathEnvVar)
+	return vm
+}
+
+func (c *Config) makeVM(pathEnvVar string) (*jsonnet.VM, *Importer) {
 	vm := jsonnet.MakeVM()
 	i := &Importer{}
 	vm.Importer(i)
 	c.ConfigureImporter(i, pathEnvVar)
 	c.ConfigureVM(vm)
-	return vm
+	return vm, i
 }
 
-// ConfigureImporter sets up a jsonnext.Importer with the import path from
-// the config and from a PATH-style environment variable. If envvar is the
-// empty string, no paths are taken from the environment.
+// ConfigureImporter sets up a jsonnext.Importer with the import path and
+// import limits from the config and with import paths from a PATH-style
+// environment variable. If envvar is the empty string, no paths are taken from
+// the environment.
 func (c *Config) ConfigureImporter(i *Importer, envvar string) {
 	i.SearchPath = c.ImportPath
+	i.MaxImports = c.MaxImports
+	i.MaxImportBytes = c.MaxImportBytes
 	if envvar != "" {
 		i.AppendSearchFromEnv(envvar)
 	}
 }
 
-// ConfigureVM sets the ExtVars and TLAVars in the jsonnet VM.
+// ConfigureVM sets the ExtVars, TLAVars and Natives in the jsonnet VM.
 func (c *Config) ConfigureVM(vm *jsonnet.VM) {
 	c.ExtVars.ConfigureVM(vm)
 	c.TLAVars.ConfigureVM(vm)
+	for _, nf := range c.Natives {
+		vm.NativeFunction(nf)
+	}
 	vm.MaxStack = c.MaxStack
 	vm.ErrorFormatter.SetMaxStackTraceSize(c.MaxTrace)
 }
 
+// MarshalJSON returns c encoded as JSON, in the same format as a project
+// config file (see ConfigFile). Natives are not included. An error wrapping
+// ErrSecretVar is returned if c holds a secret var, as the config file cannot
+// hold them (see NewSecret). It implements the json.Marshaler interface.
+func (c *Config) MarshalJSON() ([]byte, error) {
+	f, err := newConfigFile(c)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(f)
+}
+
+// UnmarshalJSON sets c from JSON in the format of a project config file, as
+// produced by MarshalJSON. Fields not present in the JSON are set to their
+// defaults and profiles are ignored. Relative paths are left as they are.
+// Natives, NoCommands and CommandTimeout, which are not part of the JSON, are
+// kept as they are in c. It implements the json.Unmarshaler interface.
+func (c *Config) UnmarshalJSON(b []byte) error {
+	f := &ConfigFile{}
+	if err := json.Unmarshal(b, f); err != nil {
+		return err
+	}
+	natives, noCommands, cmdTimeout := c.Natives, c.NoCommands, c.CommandTimeout
+	*c = *NewConfig()
+	c.Natives, c.NoCommands, c.CommandTimeout = natives, noCommands, cmdTimeout
+	return f.apply(c)
+}
+
+// Args returns command line arguments that parse into a Config equal to c
+// with ConfigFlags or the kong Config, when no other values are taken from
+// the environment or a project config file. Natives are not included. Secret
+// vars set by VMVarMap.SetSecretVar are given with their secret flag and
+// source, such as "--ext-str-secret=token=token.txt", and read their value
+// from it again. An error wrapping ErrSecretVar is returned for other secret
+// vars (see NewSecret).
+//
+// The arguments are in the form "--flag=value". Note that some parsers, such
+// as kong, reject values starting with "-" even in that form. Import paths
+// are in order, followed by the ExtVars and TLAVars sorted by name, MaxStack,
+// MaxTrace, and Timeout, MaxOutput, MaxImports and MaxImportBytes if they are
+// set.
+func (c *Config) Args() ([]string, error) {
+	args := make([]string, 0, len(c.ImportPath)+len(c.ExtVars)+len(c.TLAVars)+3) //nolint:gomnd
+	for _, p := range c.ImportPath {
+		args = append(args, "--jpath="+p)
+	}
+	for _, m := range []VMVarMap{c.ExtVars, c.TLAVars} {
+		varArgs, err := m.args()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, varArgs...)
+	}
+	args = append(args, "--max-stack="+strconv.Itoa(c.MaxStack))
+	args = append(args, "--max-trace="+strconv.Itoa(c.MaxTrace))
+	if c.Timeout != 0 {
+		args = append(args, "--timeout="+c.Timeout.String())
+	}
+	for _, limit := range []struct {
+		flag string
+		val  int
+	}{
+		{"max-output", c.MaxOutput},
+		{"max-imports", c.MaxImports},
+		{"max-import-bytes", c.MaxImportBytes},
+	} {
+		if limit.val != 0 {
+			args = append(args, "--"+limit.flag+"="+strconv.Itoa(limit.val))
+		}
+	}
+	return args, nil
+}
+
 // VMVarMap is a map of VMVars that contains a common namespace for variable
 // names. The values of type VMVar know how to set themselves in a given VM.
 type VMVarMap map[string]VMVar
@@ -87,12 +227,31 @@ func (m VMVarMap) ConfigureVM(vm *jsonnet.VM) {
 	}
 }
 
+// args returns the command line arguments to set the vars in m, sorted by
+// name. Secret vars are given as described for Config.Args.
+func (m VMVarMap) args() ([]string, error) {
+	args := make([]string, 0, len(m))
+	for _, name := range sortedNames(m) {
+		v := m[name]
+		if s, ok := v.(secretVar); ok {
+			if s.arg == "" {
+				return nil, errs.Errorf("%v: %s has no known source", ErrSecretVar, name)
+			}
+			args = append(args, "--"+string(v.Kind())+"-secret="+s.arg)
+			continue
+		}
+		args = append(args, "--"+string(v.Kind())+"="+name+"="+v.Value())
+	}
+	return args, nil
+}
+
 // SetVar sets a variable in m parsing the key and value from the given string
 // v, using makevar to construct the VMVar value. If the value is omitted from
 // the string, the value is taken from an environment variable of the same name
 // as the key. An error is returned if the environment variable does not exist,
 // or if the value string cannot be parsed due to a missing key or value (when
-// required).
+// required). A var already in m is replaced, even if one of it and the new var
+// is a string and the other code, such as with "--ext-str x=1 --ext-code x=2".
 //
 // makevar will typically be one of the VMVar constructor functions in this
 // package - New{Ext,TLA}{Str,Code}{,File}.
@@ -112,6 +271,142 @@ func (m VMVarMap) SetVar(v string, makevar func(string) VMVar) error {
 	return nil
 }
 
+// SetVarsFromFile sets a var in m for each top-level field of the JSON or YAML
+// object in filename, named by the field. Fields with a string value are set
+// as strings with makeStr and other fields are set as code, encoded as JSON,
+// with makeCode. Vars already in m are replaced.
+//
+// makeStr and makeCode will typically be NewExtStr and NewExtCode, or
+// NewTLAStr and NewTLACode.
+func (m VMVarMap) SetVarsFromFile(filename string, makeStr, makeCode func(string) VMVar) error {
+	b, err := ioutil.ReadFile(filename) //nolint:gosec // We want to read user specified files.
+	if err != nil {
+		return err
+	}
+	var fields map[string]interface{}
+	if err := yaml.Unmarshal(b, &fields); err != nil {
+		return errs.Errorf("%s: %v", filename, err)
+	}
+	for name, val := range fields {
+		if s, ok := val.(string); ok {
+			m[name] = makeStr(s)
+			continue
+		}
+		code, err := valueCode(val)
+		if err != nil {
+			return errs.Errorf("%s: field %s: %v", filename, name, err)
+		}
+		m[name] = makeCode(code)
+	}
+	return nil
+}
+
+// SetVarsFromEnv sets a var in m for each environment variable whose name
+// starts with prefix, using makevar to construct the VMVar from its value. The
+// var is named after the environment variable with prefix removed, and
+// variables named just prefix are ignored. Vars already in m are replaced. An
+// error wrapping ErrMissingKey is returned if prefix is empty.
+func (m VMVarMap) SetVarsFromEnv(prefix string, makevar func(string) VMVar) error {
+	if prefix == "" {
+		return errs.Errorf("%v: empty environment variable prefix", ErrMissingKey)
+	}
+	for _, kv := range os.Environ() {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], prefix) || parts[0] == prefix {
+			continue
+		}
+		m[strings.TrimPrefix(parts[0], prefix)] = makevar(parts[1])
+	}
+	return nil
+}
+
+// SetVarPath sets a field of an object var in m, parsing a dotted path and a
+// value from the given string v as "name.field.subfield=value", and using
+// makevar, typically NewExtCode, to construct the VMVar of the object encoded
+// as JSON. Setting more fields of the same var merges them into one object, so
+//  app.replicas=3
+//  app.image=nginx
+// set the var "app" to {"image": "nginx", "replicas": 3}. If the path has no
+// fields, the var is set to the value itself.
+//
+// The value is auto-typed: a JSON number, true, false or null is set as that
+// type and anything else as a string. A JSON string literal, such as "3", is
+// always set as a string, so quoting a value forces it to be a string.
+//
+// A field is only set in a var already in m if it is a VMVar of the kind made
+// by makevar holding a JSON object, such as one set by SetVarPath. An error is
+// returned if it is any other var, or a secret var, as well as if the path
+// has an empty element or the value is missing, or if an intermediate field
+// of the path is already set to something other than an object. A var set
+// without fields replaces the var in m, as for SetVar.
+func (m VMVarMap) SetVarPath(v string, makevar func(string) VMVar) error {
+	parts := strings.SplitN(v, "=", 2)
+	if len(parts) == 1 {
+		return errs.Errorf("%v: %s", ErrMissingValue, v)
+	}
+	path := strings.Split(parts[0], ".")
+	for _, p := range path {
+		if p == "" {
+			return errs.Errorf(`%v in "%s"`, ErrMissingKey, v)
+		}
+	}
+	name, val := path[0], autoType(parts[1])
+	if len(path) == 1 {
+		code, err := valueCode(val)
+		if err != nil {
+			return err
+		}
+		m[name] = makevar(code)
+		return nil
+	}
+
+	obj := map[string]interface{}{}
+	if old, ok := m[name]; ok {
+		if IsSecret(old) || old.Kind() != makevar("").Kind() {
+			return errs.Errorf("%v: %s is already set as a %s var", ErrInvalidValue, name, old.Kind())
+		}
+		dec := json.NewDecoder(strings.NewReader(old.Value()))
+		dec.UseNumber()
+		if err := dec.Decode(&obj); err != nil || obj == nil {
+			return errs.Errorf("%v: %s is not an object", ErrInvalidValue, name)
+		}
+	}
+	fields := obj
+	for i, key := range path[1 : len(path)-1] {
+		next, ok := fields[key]
+		if !ok {
+			next = map[string]interface{}{}
+			fields[key] = next
+		}
+		if fields, ok = next.(map[string]interface{}); !ok {
+			return errs.Errorf("%v: %s is not an object", ErrInvalidValue, strings.Join(path[:i+2], "."))
+		}
+	}
+	fields[path[len(path)-1]] = val
+	code, err := valueCode(obj)
+	if err != nil {
+		return err
+	}
+	m[name] = makevar(code)
+	return nil
+}
+
+// autoType returns s as a JSON number, bool, null or string if it is one of
+// those JSON literals, and as the string s otherwise.
+func autoType(s string) interface{} {
+	var v interface{}
+	if s != strings.TrimSpace(s) || json.Unmarshal([]byte(s), &v) != nil {
+		return s
+	}
+	switch v.(type) {
+	case float64:
+		return json.Number(s)
+	case bool, string, nil:
+		return v
+	}
+	return s
+}
+
 // VMVar is a variable that can be set in a jsonnet VM, either as an external
 // variable (extVar) or a top-level arg (TLA), as a string or code. Variants of
 // VMVars that take the string or code from a file are turned into jsonnet
@@ -120,8 +415,28 @@ func (m VMVarMap) SetVar(v string, makevar func(string) VMVar) error {
 // standard jsonnet binary.
 type VMVar interface {
 	Set(key string, vm *jsonnet.VM)
+	// Kind returns the kind of the VMVar.
+	Kind() VMVarKind
+	// Value returns the string, code or filename of the VMVar.
+	Value() string
 }
 
+// VMVarKind identifies the kind of a VMVar. The kinds are named after the
+// command line flag used to set a VMVar of that kind.
+type VMVarKind string
+
+// Kinds of VMVars, one for each of the VMVar constructors.
+const (
+	KindExtStr      VMVarKind = "ext-str"
+	KindExtCode     VMVarKind = "ext-code"
+	KindExtStrFile  VMVarKind = "ext-str-file"
+	KindExtCodeFile VMVarKind = "ext-code-file"
+	KindTLAStr      VMVarKind = "tla-str"
+	KindTLACode     VMVarKind = "tla-code"
+	KindTLAStrFile  VMVarKind = "tla-str-file"
+	KindTLACodeFile VMVarKind = "tla-code-file"
+)
+
 type (
 	extStr      string
 	extCode     string
@@ -136,38 +451,86 @@ type (
 // NewExtStr constructs a VMVar as a string external variable.
 func NewExtStr(s string) VMVar                  { return extStr(s) }
 func (v extStr) Set(key string, vm *jsonnet.VM) { vm.ExtVar(key, string(v)) }
+func (v extStr) Kind() VMVarKind                { return KindExtStr }
+func (v extStr) Value() string                  { return string(v) }
 
 // NewExtCode constructs a VMVar as a code external variable.
 func NewExtCode(s string) VMVar                  { return extCode(s) }
 func (v extCode) Set(key string, vm *jsonnet.VM) { vm.ExtCode(key, string(v)) }
+func (v extCode) Kind() VMVarKind                { return KindExtCode }
+func (v extCode) Value() string                  { return string(v) }
 
 // NewExtStrFile constructs a VMVar as a string external variable to be read
 // from a file.
 func NewExtStrFile(s string) VMVar                  { return extStrFile(s) }
 func (v extStrFile) Set(key string, vm *jsonnet.VM) { vm.ExtCode(key, mkImportStr(string(v))) }
+func (v extStrFile) Kind() VMVarKind                { return KindExtStrFile }
+func (v extStrFile) Value() string                  { return string(v) }
 
 // NewExtCodeFile constructs a VMVar as a code external variable to be read
 // from a file.
 func NewExtCodeFile(s string) VMVar                  { return extCodeFile(s) }
 func (v extCodeFile) Set(key string, vm *jsonnet.VM) { vm.ExtCode(key, mkImport(string(v))) }
+func (v extCodeFile) Kind() VMVarKind                { return KindExtCodeFile }
+func (v extCodeFile) Value() string                  { return string(v) }
 
 // NewTLAStr constructs a VMVar as a string top-level arg.
 func NewTLAStr(s string) VMVar                  { return tlaStr(s) }
 func (v tlaStr) Set(key string, vm *jsonnet.VM) { vm.TLAVar(key, string(v)) }
+func (v tlaStr) Kind() VMVarKind                { return KindTLAStr }
+func (v tlaStr) Value() string                  { return string(v) }
 
 // NewTLACode constructs a VMVar as a code top-level arg.
 func NewTLACode(s string) VMVar                  { return tlaCode(s) }
 func (v tlaCode) Set(key string, vm *jsonnet.VM) { vm.TLACode(key, string(v)) }
+func (v tlaCode) Kind() VMVarKind                { return KindTLACode }
+func (v tlaCode) Value() string                  { return string(v) }
 
 // NewTLAStrFile constructs a VMVar as a string top-level arg to be read
 // from a file.
 func NewTLAStrFile(s string) VMVar                  { return tlaStrFile(s) }
 func (v tlaStrFile) Set(key string, vm *jsonnet.VM) { vm.TLACode(key, mkImportStr(string(v))) }
+func (v tlaStrFile) Kind() VMVarKind                { return KindTLAStrFile }
+func (v tlaStrFile) Value() string                  { return string(v) }
 
 // NewTLACodeFile constructs a VMVar as a code top-level arg to be read
 // from a file.
 func NewTLACodeFile(s string) VMVar                  { return tlaCodeFile(s) }
 func (v tlaCodeFile) Set(key string, vm *jsonnet.VM) { vm.TLACode(key, mkImport(string(v))) }
+func (v tlaCodeFile) Kind() VMVarKind                { return KindTLACodeFile }
+func (v tlaCodeFile) Value() string                  { return string(v) }
+
+// NewExtValue constructs a VMVar as a code external variable from the Go value
+// v, such as a struct with json tags, a map or a slice. v is encoded as JSON,
+// which is a valid jsonnet literal, so the resulting VMVar is the same as one
+// constructed with NewExtCode from that JSON. An error wrapping
+// ErrInvalidValue is returned if v cannot be encoded as JSON.
+func NewExtValue(v interface{}) (VMVar, error) {
+	code, err := valueCode(v)
+	if err != nil {
+		return nil, err
+	}
+	return extCode(code), nil
+}
+
+// NewTLAValue constructs a VMVar as a code top-level arg from the Go value v,
+// as described for NewExtValue.
+func NewTLAValue(v interface{}) (VMVar, error) {
+	code, err := valueCode(v)
+	if err != nil {
+		return nil, err
+	}
+	return tlaCode(code), nil
+}
+
+// valueCode returns the Go value v as a jsonnet code literal.
+func valueCode(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", errs.Errorf("%v: %v", ErrInvalidValue, err)
+	}
+	return string(b), nil
+}
 
 // Quote string using verbatim string: @'...'.
 func quoteStr(s string) string    { return "@'" + strings.ReplaceAll(s, "'", "''") + "'" }
@@ -0,0 +1,314 @@
+package jsonnext
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"foxygo.at/s/errs"
+	jsonnet "github.com/google/go-jsonnet"
+	"gopkg.in/yaml.v3"
+)
+
+// Names of project config files, in the order they are looked for in each
+// directory by FindConfigFile. A ".jsonnet" config file is evaluated with
+// jsonnet and must evaluate to an object. A ".yaml" config file is read as
+// YAML.
+const (
+	ConfigFileJsonnet = ".jnx.jsonnet"
+	ConfigFileYAML    = "jnx.yaml"
+)
+
+// ErrUnknownProfile is returned when a profile is selected that is not
+// defined in the project config file.
+var ErrUnknownProfile = errors.New("unknown profile")
+
+// ErrNetpathImport is returned when a ".jsonnet" project config file imports
+// a netpath. A project config file is found and evaluated implicitly, so it
+// may only import local files.
+var ErrNetpathImport = errors.New("netpath import in project config file")
+
+// ConfigFile holds the contents of a project config file. The fields map to
+// the fields of Config, with a map of var names to values for each of the
+// VMVar kinds. Profiles are named alternative sets of fields. Relative paths
+// in ImportPath and the file VMVars are relative to the directory containing
+// the config file.
+//
+// An example YAML config file:
+//
+//  importPath: [lib, vendor]
+//  extStr:
+//    env: dev
+//  maxStack: 1000
+//  profiles:
+//    prod:
+//      extStr:
+//        env: prod
+type ConfigFile struct {
+	ImportPath     []string               `json:"importPath,omitempty" yaml:"importPath,omitempty"`
+	ExtStr         map[string]string      `json:"extStr,omitempty" yaml:"extStr,omitempty"`
+	ExtStrFile     map[string]string      `json:"extStrFile,omitempty" yaml:"extStrFile,omitempty"`
+	ExtCode        map[string]string      `json:"extCode,omitempty" yaml:"extCode,omitempty"`
+	ExtCodeFile    map[string]string      `json:"extCodeFile,omitempty" yaml:"extCodeFile,omitempty"`
+	TLAStr         map[string]string      `json:"tlaStr,omitempty" yaml:"tlaStr,omitempty"`
+	TLAStrFile     map[string]string      `json:"tlaStrFile,omitempty" yaml:"tlaStrFile,omitempty"`
+	TLACode        map[string]string      `json:"tlaCode,omitempty" yaml:"tlaCode,omitempty"`
+	TLACodeFile    map[string]string      `json:"tlaCodeFile,omitempty" yaml:"tlaCodeFile,omitempty"`
+	MaxStack       *int                   `json:"maxStack,omitempty" yaml:"maxStack,omitempty"`
+	MaxTrace       *int                   `json:"maxTrace,omitempty" yaml:"maxTrace,omitempty"`
+	Timeout        string                 `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	MaxOutput      int                    `json:"maxOutput,omitempty" yaml:"maxOutput,omitempty"`
+	MaxImports     int                    `json:"maxImports,omitempty" yaml:"maxImports,omitempty"`
+	MaxImportBytes int                    `json:"maxImportBytes,omitempty" yaml:"maxImportBytes,omitempty"`
+	Profiles       map[string]*ConfigFile `json:"profiles,omitempty" yaml:"profiles,omitempty"`
+
+	dir string
+}
+
+// newConfigFile returns a ConfigFile with the fields of c. An error wrapping
+// ErrInvalidValue is returned if a var in c is of an unknown kind.
+func newConfigFile(c *Config) (*ConfigFile, error) {
+	maxStack, maxTrace := c.MaxStack, c.MaxTrace
+	f := &ConfigFile{
+		ImportPath:     c.ImportPath,
+		MaxStack:       &maxStack,
+		MaxTrace:       &maxTrace,
+		MaxOutput:      c.MaxOutput,
+		MaxImports:     c.MaxImports,
+		MaxImportBytes: c.MaxImportBytes,
+	}
+	if c.Timeout != 0 {
+		f.Timeout = c.Timeout.String()
+	}
+	for _, m := range []VMVarMap{c.ExtVars, c.TLAVars} {
+		for name, v := range m {
+			if IsSecret(v) {
+				return nil, errs.Errorf("%v: %s", ErrSecretVar, name)
+			}
+			vars, err := f.vars(v.Kind())
+			if err != nil {
+				return nil, err
+			}
+			if *vars == nil {
+				*vars = map[string]string{}
+			}
+			(*vars)[name] = v.Value()
+		}
+	}
+	return f, nil
+}
+
+// vars returns a pointer to the field of f holding the vars of the given kind,
+// or an error wrapping ErrInvalidValue if kind is not one of the VMVar kinds.
+func (f *ConfigFile) vars(kind VMVarKind) (*map[string]string, error) {
+	switch kind {
+	case KindExtStr:
+		return &f.ExtStr, nil
+	case KindExtStrFile:
+		return &f.ExtStrFile, nil
+	case KindExtCode:
+		return &f.ExtCode, nil
+	case KindExtCodeFile:
+		return &f.ExtCodeFile, nil
+	case KindTLAStr:
+		return &f.TLAStr, nil
+	case KindTLAStrFile:
+		return &f.TLAStrFile, nil
+	case KindTLACode:
+		return &f.TLACode, nil
+	case KindTLACodeFile:
+		return &f.TLACodeFile, nil
+	}
+	return nil, errs.Errorf("%v: unknown VMVar kind %q", ErrInvalidValue, kind)
+}
+
+// FindConfigFile looks for a project config file in dir and each of its
+// parent directories, returning the path of the first one found. If no config
+// file is found, the empty string is returned.
+func FindConfigFile(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		for _, name := range []string{ConfigFileJsonnet, ConfigFileYAML} {
+			filename := filepath.Join(dir, name)
+			if _, err := os.Stat(filename); err == nil {
+				return filename, nil
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// LoadConfigFile reads and parses the project config file filename. Files
+// with a ".jsonnet" suffix are evaluated as jsonnet and other files are parsed
+// as YAML. A ".jsonnet" file may import local files only: importing a netpath
+// fails with ErrNetpathImport.
+func LoadConfigFile(filename string) (*ConfigFile, error) {
+	b, err := ioutil.ReadFile(filename) //nolint:gosec // We want to read user specified files.
+	if err != nil {
+		return nil, err
+	}
+
+	f := &ConfigFile{}
+	if strings.HasSuffix(filename, ".jsonnet") {
+		vm := jsonnet.MakeVM()
+		vm.Importer(&Importer{Fetcher: noNetpathFetcher{}})
+		out, err := vm.EvaluateAnonymousSnippet(filename, string(b))
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal([]byte(out), f)
+	} else {
+		err = yaml.Unmarshal(b, f)
+	}
+	if err != nil {
+		return nil, errs.Errorf("%s: %v", filename, err)
+	}
+
+	f.setDir(filepath.Dir(filename))
+	return f, nil
+}
+
+// LoadProjectConfig finds the project config file in dir or its parents and
+// applies it to c with the given profile, as described for ConfigFile.Apply.
+// It is not an error if there is no project config file, unless a profile is
+// given.
+func (c *Config) LoadProjectConfig(dir, profile string) error {
+	filename, err := FindConfigFile(dir)
+	if err != nil {
+		return err
+	}
+	if filename == "" {
+		if profile != "" {
+			return errs.Errorf("%v %#v: no config file", ErrUnknownProfile, profile)
+		}
+		return nil
+	}
+	f, err := LoadConfigFile(filename)
+	if err != nil {
+		return err
+	}
+	return f.Apply(c, profile)
+}
+
+// noNetpathFetcher is the URLFetcher of the Importer evaluating a project
+// config file. It fails every fetch so that netpaths cannot be imported.
+type noNetpathFetcher struct{}
+
+func (noNetpathFetcher) Get(url string) (*http.Response, error) {
+	return nil, errs.Errorf("%v: %s", ErrNetpathImport, url)
+}
+
+// Apply sets the fields of c from the config file and the named profile in
+// it, if profile is not empty. Values already set in c take precedence over
+// those in the config file, and values in the profile take precedence over
+// the top-level values of the file. This allows a Config to be populated from
+// the command line first and then have any gaps filled from the config file.
+//
+// VMVars are only set if a var of the same name is not already set. Import
+// paths are appended to the import path in c. MaxStack, MaxTrace, Timeout and
+// the MaxOutput, MaxImports and MaxImportBytes limits are only set if they
+// have not already been set, as described for Config.MarkSet, and are marked
+// as set. Timeout is a duration such as "30s".
+func (f *ConfigFile) Apply(c *Config, profile string) error {
+	if profile != "" {
+		p, ok := f.Profiles[profile]
+		if !ok {
+			return errs.Errorf("%v: %#v", ErrUnknownProfile, profile)
+		}
+		if p != nil {
+			if err := p.apply(c); err != nil {
+				return err
+			}
+		}
+	}
+	return f.apply(c)
+}
+
+func (f *ConfigFile) apply(c *Config) error {
+	for _, p := range f.ImportPath {
+		c.ImportPath = append(c.ImportPath, f.path(p))
+	}
+
+	setVars(c.ExtVars, f.ExtStr, NewExtStr, nil)
+	setVars(c.ExtVars, f.ExtStrFile, NewExtStrFile, f.path)
+	setVars(c.ExtVars, f.ExtCode, NewExtCode, nil)
+	setVars(c.ExtVars, f.ExtCodeFile, NewExtCodeFile, f.path)
+	setVars(c.TLAVars, f.TLAStr, NewTLAStr, nil)
+	setVars(c.TLAVars, f.TLAStrFile, NewTLAStrFile, f.path)
+	setVars(c.TLAVars, f.TLACode, NewTLACode, nil)
+	setVars(c.TLAVars, f.TLACodeFile, NewTLACodeFile, f.path)
+
+	if f.MaxStack != nil {
+		c.fillInt(&c.MaxStack, "max-stack", maxStackDepth, *f.MaxStack)
+	}
+	if f.MaxTrace != nil {
+		c.fillInt(&c.MaxTrace, "max-trace", maxStackTraceOutput, *f.MaxTrace)
+	}
+	if f.Timeout != "" && !c.isSet("timeout", c.Timeout == 0) {
+		d, err := time.ParseDuration(f.Timeout)
+		if err != nil {
+			return errs.Errorf("invalid timeout: %v", err)
+		}
+		c.Timeout = d
+		c.MarkSet("timeout")
+	}
+	for _, limit := range []struct {
+		p    *int
+		name string
+		val  int
+	}{
+		{&c.MaxOutput, "max-output", f.MaxOutput},
+		{&c.MaxImports, "max-imports", f.MaxImports},
+		{&c.MaxImportBytes, "max-import-bytes", f.MaxImportBytes},
+	} {
+		if limit.val != 0 {
+			c.fillInt(limit.p, limit.name, 0, limit.val)
+		}
+	}
+	return nil
+}
+
+// setVars sets each var in vars that is not already in m, using makevar to
+// construct the VMVar. If mapval is not nil, it is applied to the value first.
+func setVars(m VMVarMap, vars map[string]string, makevar func(string) VMVar, mapval func(string) string) {
+	for k, v := range vars {
+		if _, ok := m[k]; ok {
+			continue
+		}
+		if mapval != nil {
+			v = mapval(v)
+		}
+		m[k] = makevar(v)
+	}
+}
+
+// path returns p relative to the directory of the config file, unless it is
+// absolute or a netpath.
+func (f *ConfigFile) path(p string) string {
+	if path.IsAbs(p) || filepath.IsAbs(p) || f.dir == "" {
+		return p
+	}
+	return filepath.Join(f.dir, p)
+}
+
+func (f *ConfigFile) setDir(dir string) {
+	f.dir = dir
+	for _, p := range f.Profiles {
+		if p != nil {
+			p.setDir(dir)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package jsonnext
+
+import (
+	"errors"
+	"flag"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindConfigFile(t *testing.T) {
+	expected, err := filepath.Abs("testdata/configfile/yaml/jnx.yaml")
+	require.NoError(t, err)
+
+	filename, err := FindConfigFile("testdata/configfile/yaml/lib")
+	require.NoError(t, err)
+	require.Equal(t, expected, filename)
+
+	filename, err = FindConfigFile("testdata/configfile/yaml")
+	require.NoError(t, err)
+	require.Equal(t, expected, filename)
+
+	filename, err = FindConfigFile("/")
+	require.NoError(t, err)
+	require.Equal(t, "", filename)
+}
+
+func TestLoadProjectConfig(t *testing.T) {
+	dir, err := filepath.Abs("testdata/configfile/yaml")
+	require.NoError(t, err)
+
+	c := NewConfig()
+	err = c.LoadProjectConfig("testdata/configfile/yaml/lib", "")
+	require.NoError(t, err)
+
+	expected := NewConfig()
+	expected.ImportPath = []string{filepath.Join(dir, "lib"), "/abs/lib", "//example.com/lib"}
+	expected.ExtVars["env"] = NewExtStr("dev")
+	expected.ExtVars["region"] = NewExtStr("us")
+	expected.ExtVars["data"] = NewExtCodeFile(filepath.Join(dir, "lib/data.json"))
+	expected.TLAVars["replicas"] = NewTLACode("1")
+	expected.MaxStack = 1000
+	expected.MarkSet("max-stack")
+	require.Equal(t, expected, c)
+}
+
+func TestLoadProjectConfigProfile(t *testing.T) {
+	c := NewConfig()
+	err := c.LoadProjectConfig("testdata/configfile/yaml", "prod")
+	require.NoError(t, err)
+	require.Equal(t, NewExtStr("prod"), c.ExtVars["env"])
+	require.Equal(t, NewExtStr("us"), c.ExtVars["region"])
+	require.Equal(t, NewTLACode("3"), c.TLAVars["replicas"])
+	require.Equal(t, 1000, c.MaxStack)
+	require.Equal(t, 50, c.MaxTrace)
+
+	c = NewConfig()
+	err = c.LoadProjectConfig("testdata/configfile/yaml", "empty")
+	require.NoError(t, err)
+	require.Equal(t, NewExtStr("dev"), c.ExtVars["env"])
+}
+
+// Test that values already in the Config, such as those set by flags, take
+// precedence over the config file.
+func TestLoadProjectConfigPrecedence(t *testing.T) {
+	c := NewConfig()
+	c.ImportPath = []string{"flag"}
+	c.ExtVars["env"] = NewExtCode("'flag'")
+	c.MaxStack = 10
+	err := c.LoadProjectConfig("testdata/configfile/yaml", "prod")
+	require.NoError(t, err)
+	require.Equal(t, NewExtCode("'flag'"), c.ExtVars["env"])
+	require.Equal(t, "flag", c.ImportPath[0])
+	require.Equal(t, 4, len(c.ImportPath))
+	require.Equal(t, 10, c.MaxStack)
+}
+
+// Test that values set by flags take precedence over the config file even if
+// they are the default values.
+func TestLoadProjectConfigExplicitDefault(t *testing.T) {
+	fs := flag.NewFlagSet(t.Name(), flag.ContinueOnError)
+	c := ConfigFlags(fs)
+	require.NoError(t, fs.Parse([]string{"-max-stack", "500", "-max-trace", "20"}))
+	err := c.LoadProjectConfig("testdata/configfile/yaml", "prod")
+	require.NoError(t, err)
+	require.Equal(t, 500, c.MaxStack)
+	require.Equal(t, 20, c.MaxTrace)
+
+	// A value set by a profile is not replaced by the top-level value.
+	c = NewConfig()
+	f := &ConfigFile{MaxStack: intPtr(1000), Profiles: map[string]*ConfigFile{"p": {MaxStack: intPtr(500)}}}
+	require.NoError(t, f.Apply(c, "p"))
+	require.Equal(t, 500, c.MaxStack)
+}
+
+func intPtr(i int) *int { return &i }
+
+func TestLoadProjectConfigUnknownProfile(t *testing.T) {
+	c := NewConfig()
+	err := c.LoadProjectConfig("testdata/configfile/yaml", "staging")
+	require.Error(t, err)
+	require.True(t, errors.Is(err, ErrUnknownProfile), "error should be ErrUnknownProfile")
+
+	err = c.LoadProjectConfig("/", "staging")
+	require.Error(t, err)
+	require.True(t, errors.Is(err, ErrUnknownProfile), "error should be ErrUnknownProfile")
+}
+
+func TestLoadConfigFileJsonnet(t *testing.T) {
+	f, err := LoadConfigFile("testdata/configfile/jsonnet/.jnx.jsonnet")
+	require.NoError(t, err)
+	c := NewConfig()
+	require.NoError(t, f.Apply(c, "prod"))
+	require.Equal(t, NewExtStr("prod"), c.ExtVars["env"])
+}
+
+func TestLoadConfigFileNetpath(t *testing.T) {
+	_, err := LoadConfigFile("testdata/configfile/netpath/.jnx.jsonnet")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), ErrNetpathImport.Error())
+}
+
+func TestNoNetpathFetcher(t *testing.T) {
+	i := &Importer{Fetcher: noNetpathFetcher{}}
+	_, _, err := i.Import("", "//example.com/lib.libsonnet")
+	require.Error(t, err)
+	require.True(t, errors.Is(err, ErrNetpathImport))
+
+	_, _, err = i.Import("", "testdata/configfile/yaml/jnx.yaml")
+	require.NoError(t, err)
+}
+
+func TestLoadConfigFileError(t *testing.T) {
+	_, err := LoadConfigFile("testdata/configfile/nonexistent.yaml")
+	require.Error(t, err)
+	_, err = LoadConfigFile("testdata/config/code")
+	require.Error(t, err)
+}
@@ -1,9 +1,12 @@
 package jsonnext
 
 import (
+	"encoding/json"
 	"errors"
+	"math"
 	"strings"
 	"testing"
+	"time"
 
 	"foxygo.at/s/test"
 	jsonnet "github.com/google/go-jsonnet"
@@ -50,6 +53,38 @@ func TestVars(t *testing.T) {
 	}
 }
 
+func TestNewValue(t *testing.T) {
+	type request struct {
+		User   string            `json:"user"`
+		Tags   []string          `json:"tags"`
+		Labels map[string]string `json:"labels,omitempty"`
+		Quota  float64           `json:"quota"`
+	}
+	val := request{User: "it's \"me\"\n", Tags: []string{"a", "<b>"}, Quota: 1.5}
+	expected := `{"user":"it's \"me\"\n","tags":["a","\u003cb\u003e"],"quota":1.5}`
+
+	ext, err := NewExtValue(val)
+	require.NoError(t, err)
+	require.Equal(t, NewExtCode(expected), ext)
+	tla, err := NewTLAValue(&val)
+	require.NoError(t, err)
+	require.Equal(t, NewTLACode(expected), tla)
+
+	c := NewConfig()
+	c.ExtVars["req"] = ext
+	c.TLAVars["req"] = tla
+	vm := jsonnet.MakeVM()
+	c.ConfigureVM(vm)
+	got, err := vm.EvaluateAnonymousSnippet("<literal>", "function(req) [req.tags[1], std.extVar('req').user]")
+	require.NoError(t, err)
+	require.JSONEq(t, `["<b>", "it's \"me\"\n"]`, got)
+
+	_, err = NewExtValue(make(chan int))
+	require.True(t, errors.Is(err, ErrInvalidValue), "%v", err)
+	_, err = NewTLAValue(math.Inf(1))
+	require.True(t, errors.Is(err, ErrInvalidValue), "%v", err)
+}
+
 func TestSetVar(t *testing.T) {
 	m := VMVarMap{}
 	err := m.SetVar("var=val", NewExtStr)
@@ -84,6 +119,69 @@ func TestSetVarNoValue(t *testing.T) {
 	require.True(t, errors.Is(err, ErrMissingValue), "error should be ErrMissingValue")
 }
 
+func TestSetVarsFromEnv(t *testing.T) {
+	test.Env.Set("VARS_TEST_x", "1")
+	defer test.Env.Restore()
+	m := VMVarMap{}
+	require.NoError(t, m.SetVarsFromEnv("VARS_TEST_", NewTLACode))
+	require.Equal(t, VMVarMap{"x": NewTLACode("1")}, m)
+
+	err := m.SetVarsFromEnv("", NewTLACode)
+	require.True(t, errors.Is(err, ErrMissingKey), "%v", err)
+}
+
+func TestAutoType(t *testing.T) {
+	tests := map[string]interface{}{
+		"3":       json.Number("3"),
+		"-1.5e3":  json.Number("-1.5e3"),
+		"true":    true,
+		"null":    nil,
+		`"3"`:     "3",
+		"nginx":   "nginx",
+		"[1, 2]":  "[1, 2]",
+		" 3":      " 3",
+		"":        "",
+		`{"a":1}`: `{"a":1}`,
+	}
+	for in, expected := range tests {
+		require.Equal(t, expected, autoType(in), in)
+	}
+}
+
+func TestSetVarPath(t *testing.T) {
+	m := VMVarMap{"app": NewExtCode("{}")}
+	require.NoError(t, m.SetVarPath("app.a.b=1", NewExtCode))
+	require.NoError(t, m.SetVarPath("app.a.c=x", NewExtCode))
+	require.NoError(t, m.SetVarPath("top=false", NewExtCode))
+	expected := VMVarMap{
+		"app": NewExtCode(`{"a":{"b":1,"c":"x"}}`),
+		"top": NewExtCode("false"),
+	}
+	require.Equal(t, expected, m)
+
+	err := m.SetVarPath("app.a.b.c=2", NewExtCode)
+	require.True(t, errors.Is(err, ErrInvalidValue), "%v", err)
+	err = m.SetVarPath("app..b=2", NewExtCode)
+	require.True(t, errors.Is(err, ErrMissingKey), "%v", err)
+	err = m.SetVarPath("app.b", NewExtCode)
+	require.True(t, errors.Is(err, ErrMissingValue), "%v", err)
+	require.Equal(t, expected, m)
+
+	// Fields are not set in vars that are not objects of the same kind.
+	m = VMVarMap{
+		"str":    NewExtStr("{}"),
+		"file":   NewExtCodeFile("app.json"),
+		"list":   NewExtCode("[1]"),
+		"secret": NewSecret(NewExtCode("{}")),
+	}
+	for name := range m {
+		err = m.SetVarPath(name+".a=1", NewExtCode)
+		require.True(t, errors.Is(err, ErrInvalidValue), "%s: %v", name, err)
+	}
+	require.NoError(t, m.SetVarPath("list=2", NewExtCode))
+	require.Equal(t, NewExtCode("2"), m["list"])
+}
+
 func TestMakeVM(t *testing.T) {
 	c := NewConfig()
 
@@ -131,3 +229,90 @@ func TestConfigureImporterFromConfigAndEnv(t *testing.T) {
 	c.ConfigureImporter(&i, "JPATH")
 	require.Equal(t, []string{"a", "b", "c", "d"}, i.SearchPath)
 }
+
+func newFullConfig() *Config {
+	c := NewConfig()
+	c.ImportPath = []string{"b", "a", "-dash"}
+	c.ExtVars["str"] = NewExtStr("x=y")
+	c.ExtVars["code"] = NewExtCode("{ a: 1 }")
+	c.ExtVars["sf"] = NewExtStrFile("s.txt")
+	c.ExtVars["cf"] = NewExtCodeFile("c.jsonnet")
+	c.TLAVars["str"] = NewTLAStr("")
+	c.TLAVars["code"] = NewTLACode("-1")
+	c.TLAVars["sf"] = NewTLAStrFile("s.txt")
+	c.TLAVars["cf"] = NewTLACodeFile("c.jsonnet")
+	c.MaxStack = 10
+	c.Timeout = 90 * time.Second
+	c.MaxOutput = 1 << 20
+	c.MaxImports = 100
+	return c
+}
+
+func TestConfigJSON(t *testing.T) {
+	c := newFullConfig()
+	b, err := json.Marshal(c)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"importPath": ["b", "a", "-dash"],
+		"extStr": {"str": "x=y"},
+		"extCode": {"code": "{ a: 1 }"},
+		"extStrFile": {"sf": "s.txt"},
+		"extCodeFile": {"cf": "c.jsonnet"},
+		"tlaStr": {"str": ""},
+		"tlaCode": {"code": "-1"},
+		"tlaStrFile": {"sf": "s.txt"},
+		"tlaCodeFile": {"cf": "c.jsonnet"},
+		"maxStack": 10,
+		"maxTrace": 20,
+		"timeout": "1m30s",
+		"maxOutput": 1048576,
+		"maxImports": 100
+	}`, string(b))
+
+	got := &Config{}
+	require.NoError(t, json.Unmarshal(b, got))
+	c.MarkSet("max-stack", "max-trace", "timeout", "max-output", "max-imports")
+	require.Equal(t, c, got)
+
+	require.NoError(t, json.Unmarshal([]byte(`{}`), got))
+	require.Equal(t, NewConfig(), got)
+}
+
+func TestConfigJSONKeepsNonJSONFields(t *testing.T) {
+	got := NewConfig()
+	got.NoCommands = true
+	got.Natives = []*jsonnet.NativeFunction{{Name: "f"}}
+	got.CommandTimeout = time.Second
+	require.NoError(t, json.Unmarshal([]byte(`{"maxStack": 10}`), got))
+	require.True(t, got.NoCommands)
+	require.Equal(t, time.Second, got.CommandTimeout)
+	require.Equal(t, []*jsonnet.NativeFunction{{Name: "f"}}, got.Natives)
+	require.Equal(t, 10, got.MaxStack)
+}
+
+type otherVar string
+
+func (v otherVar) Set(key string, vm *jsonnet.VM) { vm.ExtVar(key, string(v)) }
+func (v otherVar) Kind() VMVarKind                { return "other" }
+func (v otherVar) Value() string                  { return string(v) }
+
+func TestConfigJSONUnknownKind(t *testing.T) {
+	c := NewConfig()
+	c.ExtVars["x"] = otherVar("y")
+	_, err := json.Marshal(c)
+	require.Error(t, err)
+	require.True(t, errors.Is(err, ErrInvalidValue))
+}
+
+func TestConfigArgs(t *testing.T) {
+	expected := []string{
+		"--jpath=b", "--jpath=a", "--jpath=-dash",
+		"--ext-code-file=cf=c.jsonnet", "--ext-code=code={ a: 1 }", "--ext-str-file=sf=s.txt", "--ext-str=str=x=y",
+		"--tla-code-file=cf=c.jsonnet", "--tla-code=code=-1", "--tla-str-file=sf=s.txt", "--tla-str=str=",
+		"--max-stack=10", "--max-trace=20", "--timeout=1m30s",
+		"--max-output=1048576", "--max-imports=100",
+	}
+	args, err := newFullConfig().Args()
+	require.NoError(t, err)
+	require.Equal(t, expected, args)
+}
@@ -4,8 +4,14 @@
 package conformance
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"foxygo.at/s/test"
 	"github.com/stretchr/testify/require"
@@ -169,3 +175,363 @@ func (s *Suite) TestMaxTrace() {
 	require.NoError(t, err)
 	require.Equal(t, 20, cfg.MaxTrace)
 }
+
+// TestTimeout tests that the Timeout field is set by the --timeout flag, and
+// that there is no timeout when the flag is not present.
+func (s *Suite) TestTimeout() {
+	t := s.T()
+
+	cfg, err := s.parser.Parse(t, []string{t.Name(), "--timeout", "1m30s"})
+	require.NoError(t, err)
+	require.Equal(t, 90*time.Second, cfg.Timeout)
+
+	cfg, err = s.parser.Parse(t, []string{t.Name()})
+	require.NoError(t, err)
+	require.Equal(t, time.Duration(0), cfg.Timeout)
+
+	_, err = s.parser.Parse(t, []string{t.Name(), "--timeout", "soon"})
+	require.Error(t, err)
+}
+
+// TestLimits tests that the MaxOutput, MaxImports and MaxImportBytes fields
+// are set by the --max-output, --max-imports and --max-import-bytes flags, and
+// have no limit by default.
+func (s *Suite) TestLimits() {
+	t := s.T()
+	args := []string{t.Name(), "--max-output", "1000", "--max-imports", "10", "--max-import-bytes", "5000"}
+	cfg, err := s.parser.Parse(t, args)
+	require.NoError(t, err)
+	require.Equal(t, 1000, cfg.MaxOutput)
+	require.Equal(t, 10, cfg.MaxImports)
+	require.Equal(t, 5000, cfg.MaxImportBytes)
+
+	cfg, err = s.parser.Parse(t, []string{t.Name()})
+	require.NoError(t, err)
+	require.Equal(t, 0, cfg.MaxOutput)
+	require.Equal(t, 0, cfg.MaxImports)
+	require.Equal(t, 0, cfg.MaxImportBytes)
+}
+
+// TestVarsFile tests that the --ext-vars-file and --tla-vars-file flags set a
+// var for each field of a JSON or YAML file, as a string for string values and
+// as code for other values, and that a missing file is an error.
+func (s *Suite) TestVarsFile() {
+	dir, err := ioutil.TempDir("", "jnx-conformance-")
+	require.NoError(s.T(), err)
+	defer os.RemoveAll(dir) //nolint:errcheck
+
+	files := map[string]string{
+		"vars.json": `{"str": "hello", "num": 1, "obj": {"a": [true, null]}}`,
+		"vars.yaml": "str: hello\nnum: 1\nobj:\n  a: [true, null]\n",
+	}
+	for name, content := range files {
+		filename := filepath.Join(dir, name)
+		require.NoError(s.T(), ioutil.WriteFile(filename, []byte(content), 0o600))
+		s.T().Run(name, func(t *testing.T) {
+			args := []string{t.Name(), "--ext-vars-file", filename, "--tla-vars-file", filename}
+			cfg, err := s.parser.Parse(t, args)
+			require.NoError(t, err)
+			expected := jsonnext.NewConfig()
+			expected.ExtVars = jsonnext.VMVarMap{
+				"str": jsonnext.NewExtStr("hello"),
+				"num": jsonnext.NewExtCode("1"),
+				"obj": jsonnext.NewExtCode(`{"a":[true,null]}`),
+			}
+			expected.TLAVars = jsonnext.VMVarMap{
+				"str": jsonnext.NewTLAStr("hello"),
+				"num": jsonnext.NewTLACode("1"),
+				"obj": jsonnext.NewTLACode(`{"a":[true,null]}`),
+			}
+			require.Equal(t, expected, cfg)
+		})
+	}
+
+	t := s.T()
+	for _, flag := range []string{"--ext-vars-file", "--tla-vars-file"} {
+		_, err = s.parser.Parse(t, []string{t.Name(), flag, filepath.Join(dir, "missing.json")})
+		require.Error(t, err)
+	}
+	notObject := filepath.Join(dir, "list.json")
+	require.NoError(t, ioutil.WriteFile(notObject, []byte(`[1, 2]`), 0o600))
+	_, err = s.parser.Parse(t, []string{t.Name(), "--ext-vars-file", notObject})
+	require.Error(t, err)
+}
+
+// TestExtStrEnvPrefix tests that the --ext-str-env-prefix flag sets a string
+// extVar for each environment variable starting with the prefix, named
+// without the prefix.
+func (s *Suite) TestExtStrEnvPrefix() {
+	t := s.T()
+	setEnv(map[string]string{
+		"JNXTEST_APP_ONE": "1",
+		"JNXTEST_APP_two": "two",
+		"JNXTEST_APP_":    "ignored",
+		"JNXTEST_APPX":    "ignored",
+	})
+	defer test.Env.Restore()
+
+	args := []string{t.Name(), "--ext-code", "ONE=0", "--ext-str-env-prefix", "JNXTEST_APP_"}
+	cfg, err := s.parser.Parse(t, args)
+	require.NoError(t, err)
+	expected := jsonnext.NewConfig()
+	expected.ExtVars = jsonnext.VMVarMap{
+		"ONE": jsonnext.NewExtStr("1"),
+		"two": jsonnext.NewExtStr("two"),
+	}
+	require.Equal(t, expected, cfg)
+}
+
+// TestSecretVars tests that the secret VMVar flags read the value from a file
+// or the environment, redact it when the VMVarMap is printed, and round trip
+// through Config.Args with their source.
+func (s *Suite) TestSecretVars() {
+	dir, err := ioutil.TempDir("", "jnx-conformance-")
+	require.NoError(s.T(), err)
+	defer os.RemoveAll(dir) //nolint:errcheck
+	filename := filepath.Join(dir, "secret")
+	require.NoError(s.T(), ioutil.WriteFile(filename, []byte("s3cret\n"), 0o600))
+
+	secretFlags := map[string]func(string) jsonnext.VMVar{
+		"--ext-str-secret":  jsonnext.NewExtStr,
+		"--ext-code-secret": jsonnext.NewExtCode,
+		"--tla-str-secret":  jsonnext.NewTLAStr,
+		"--tla-code-secret": jsonnext.NewTLACode,
+	}
+	for flag, makevar := range secretFlags {
+		flag, makevar := flag, makevar
+		s.T().Run(flag, func(t *testing.T) {
+			test.Env.Set("environ", "env-s3cret")
+			defer test.Env.Restore()
+			cfg, err := s.parser.Parse(t, []string{t.Name(), flag, "file=" + filename, flag, "environ"})
+			require.NoError(t, err)
+			vars := cfg.ExtVars
+			if strings.HasPrefix(flag, "--tla-") {
+				vars = cfg.TLAVars
+			}
+			require.Equal(t, 2, len(vars))
+			for _, name := range []string{"file", "environ"} {
+				require.True(t, jsonnext.IsSecret(vars[name]), name)
+				require.Equal(t, makevar("").Kind(), vars[name].Kind(), name)
+			}
+			require.NotContains(t, fmt.Sprint(vars), "s3cret")
+
+			args, err := cfg.Args()
+			require.NoError(t, err)
+			got, err := s.parser.Parse(t, append([]string{t.Name()}, args...))
+			require.NoError(t, err)
+			cfg.MarkSet("max-stack", "max-trace")
+			require.Equal(t, cfg, got)
+
+			_, err = s.parser.Parse(t, []string{t.Name(), flag, "file=" + filepath.Join(dir, "missing")})
+			require.Error(t, err)
+		})
+	}
+}
+
+// TestCmdVars tests that the --ext-str-cmd and --tla-str-cmd flags set a
+// string var from the trimmed output of a command, and that a failing command
+// is an error.
+func (s *Suite) TestCmdVars() {
+	t := s.T()
+	if runtime.GOOS == "windows" {
+		t.Skip("commands use sh")
+	}
+	args := []string{t.Name(), "--ext-str-cmd", "sha=echo ' abc123 '", "--tla-str-cmd", "cluster=printf prod"}
+	cfg, err := s.parser.Parse(t, args)
+	require.NoError(t, err)
+	expected := jsonnext.NewConfig()
+	expected.ExtVars["sha"] = jsonnext.NewExtStr("abc123")
+	expected.TLAVars["cluster"] = jsonnext.NewTLAStr("prod")
+	require.Equal(t, expected, cfg)
+
+	for _, flag := range []string{"--ext-str-cmd", "--tla-str-cmd"} {
+		_, err = s.parser.Parse(t, []string{t.Name(), flag, "var=exit 1"})
+		require.Error(t, err)
+		_, err = s.parser.Parse(t, []string{t.Name(), flag, "var"})
+		require.Error(t, err)
+	}
+}
+
+// TestExtObj tests that the -O and --ext-obj flags build an extVar object
+// from dotted paths, with auto-typed values and quoted values as strings.
+func (s *Suite) TestExtObj() {
+	t := s.T()
+	args := []string{
+		t.Name(),
+		"-O", "app.replicas=3",
+		"--ext-obj", "app.image=nginx",
+		"-O", `app.tag="1.0"`,
+		"-O", "app.debug=true",
+		"-O", "app.limits.cpu=0.5",
+		"-O", "app.limits.memory=1Gi",
+		"-O", "app.none=null",
+		"-O", "env=prod",
+		"-O", "app.replicas=5",
+	}
+	cfg, err := s.parser.Parse(t, args)
+	require.NoError(t, err)
+	expected := jsonnext.NewConfig()
+	expected.ExtVars = jsonnext.VMVarMap{
+		"app": jsonnext.NewExtCode(`{"debug":true,"image":"nginx","limits":{"cpu":0.5,"memory":"1Gi"},"none":null,"replicas":5,"tag":"1.0"}`),
+		"env": jsonnext.NewExtCode(`"prod"`),
+	}
+	require.Equal(t, expected, cfg)
+
+	for _, arg := range []string{"app.replicas", "app..x=1", ".x=1"} {
+		_, err = s.parser.Parse(t, []string{t.Name(), "-O", arg})
+		require.Error(t, err, arg)
+	}
+	_, err = s.parser.Parse(t, []string{t.Name(), "-O", "app.x=1", "-O", "app.x.y=2"})
+	require.Error(t, err)
+	// A field is not set in a var that is not an object built by -O.
+	_, err = s.parser.Parse(t, []string{t.Name(), "-V", "app=x", "-O", "app.x=1"})
+	require.Error(t, err)
+}
+
+// TestEnv tests that every field of the Config is set from JNX_ environment
+// variables when not set on the command line.
+func (s *Suite) TestEnv() {
+	t := s.T()
+	setEnv(map[string]string{
+		"JNX_JPATH":              strings.Join([]string{"a", "b"}, string(filepath.ListSeparator)),
+		"JNX_MAX_STACK":          "10",
+		"JNX_MAX_TRACE":          "5",
+		"JNX_TIMEOUT":            "10s",
+		"JNX_MAX_OUTPUT":         "1000",
+		"JNX_MAX_IMPORTS":        "10",
+		"JNX_MAX_IMPORT_BYTES":   "5000",
+		"JNX_EXT_STR_str":        "hello",
+		"JNX_EXT_STR_FILE_sf":    "str.txt",
+		"JNX_EXT_CODE_code":      "1+1",
+		"JNX_EXT_CODE_FILE_cf":   "code.jsonnet",
+		"JNX_TLA_STR_str":        "world",
+		"JNX_TLA_STR_FILE_sf":    "str.txt",
+		"JNX_TLA_CODE_code":      "2+2",
+		"JNX_TLA_CODE_FILE_cf":   "code.jsonnet",
+		"JNX_UNRELATED_VARIABLE": "x",
+	})
+	defer test.Env.Restore()
+
+	cfg, err := s.parser.Parse(t, []string{t.Name()})
+	require.NoError(t, err)
+	expected := jsonnext.NewConfig()
+	expected.ImportPath = []string{"a", "b"}
+	expected.MaxStack = 10
+	expected.MaxTrace = 5
+	expected.Timeout = 10 * time.Second
+	expected.MaxOutput = 1000
+	expected.MaxImports = 10
+	expected.MaxImportBytes = 5000
+	expected.MarkSet("max-stack", "max-trace", "timeout", "max-output", "max-imports", "max-import-bytes")
+	expected.ExtVars = jsonnext.VMVarMap{
+		"str":  jsonnext.NewExtStr("hello"),
+		"sf":   jsonnext.NewExtStrFile("str.txt"),
+		"code": jsonnext.NewExtCode("1+1"),
+		"cf":   jsonnext.NewExtCodeFile("code.jsonnet"),
+	}
+	expected.TLAVars = jsonnext.VMVarMap{
+		"str":  jsonnext.NewTLAStr("world"),
+		"sf":   jsonnext.NewTLAStrFile("str.txt"),
+		"code": jsonnext.NewTLACode("2+2"),
+		"cf":   jsonnext.NewTLACodeFile("code.jsonnet"),
+	}
+	require.Equal(t, expected, cfg)
+}
+
+// TestEnvPrecedence tests that values on the command line take precedence
+// over the environment, and that import paths from the environment follow
+// those on the command line.
+func (s *Suite) TestEnvPrecedence() {
+	t := s.T()
+	setEnv(map[string]string{
+		"JNX_JPATH":        "env",
+		"JNX_MAX_STACK":    "10",
+		"JNX_EXT_STR_var":  "env",
+		"JNX_TLA_CODE_var": "env",
+	})
+	defer test.Env.Restore()
+
+	args := []string{t.Name(), "-J", "cli", "--max-stack", "20", "--ext-code", "var=cli", "--tla-str", "var=cli"}
+	cfg, err := s.parser.Parse(t, args)
+	require.NoError(t, err)
+	expected := jsonnext.NewConfig()
+	expected.ImportPath = []string{"cli", "env"}
+	expected.MaxStack = 20
+	expected.MarkSet("max-stack")
+	expected.ExtVars = jsonnext.VMVarMap{"var": jsonnext.NewExtCode("cli")}
+	expected.TLAVars = jsonnext.VMVarMap{"var": jsonnext.NewTLAStr("cli")}
+	require.Equal(t, expected, cfg)
+}
+
+// TestEnvDefaultFlag tests that values on the command line take precedence
+// over the environment even when they are the default values.
+func (s *Suite) TestEnvDefaultFlag() {
+	t := s.T()
+	setEnv(map[string]string{
+		"JNX_MAX_STACK":        "10",
+		"JNX_MAX_TRACE":        "5",
+		"JNX_TIMEOUT":          "10s",
+		"JNX_MAX_OUTPUT":       "1000",
+		"JNX_MAX_IMPORTS":      "10",
+		"JNX_MAX_IMPORT_BYTES": "5000",
+	})
+	defer test.Env.Restore()
+
+	args := []string{
+		t.Name(), "--max-stack", "500", "--max-trace", "20", "--timeout", "0s",
+		"--max-output", "0", "--max-imports", "0", "--max-import-bytes", "0",
+	}
+	cfg, err := s.parser.Parse(t, args)
+	require.NoError(t, err)
+	expected := jsonnext.NewConfig()
+	expected.MarkSet("max-stack", "max-trace", "timeout", "max-output", "max-imports", "max-import-bytes")
+	require.Equal(t, expected, cfg)
+}
+
+// TestEnvErr tests that an invalid integer or duration value in the
+// environment is an error.
+func (s *Suite) TestEnvErr() {
+	for _, name := range []string{"JNX_MAX_STACK", "JNX_MAX_TRACE", "JNX_TIMEOUT", "JNX_MAX_IMPORTS"} {
+		name := name
+		s.T().Run(name, func(t *testing.T) {
+			test.Env.Set(name, "many")
+			defer test.Env.Restore()
+			_, err := s.parser.Parse(t, []string{t.Name()})
+			require.Error(t, err)
+		})
+	}
+}
+
+func setEnv(env map[string]string) {
+	for k, v := range env {
+		test.Env.Set(k, v)
+	}
+}
+
+// TestRoundTrip tests that the command line arguments returned by
+// Config.Args parse into the same Config.
+func (s *Suite) TestRoundTrip() {
+	t := s.T()
+	cfg := jsonnext.NewConfig()
+	cfg.ImportPath = []string{"lib", "vendor"}
+	cfg.MaxStack = 1000
+	cfg.MaxTrace = 0
+	cfg.Timeout = 2500 * time.Millisecond
+	cfg.MaxOutput = 1 << 20
+	cfg.MaxImportBytes = 1 << 16
+	for name, tc := range flags {
+		if strings.HasPrefix(name, "ext-") {
+			cfg.ExtVars[name] = tc.makevar("-" + name + "=value")
+		} else {
+			cfg.TLAVars[name] = tc.makevar("")
+		}
+	}
+
+	args, err := cfg.Args()
+	require.NoError(t, err)
+	got, err := s.parser.Parse(t, append([]string{t.Name()}, args...))
+	require.NoError(t, err)
+	// The fields set by the flags in the args are marked as set.
+	cfg.MarkSet("max-stack", "max-trace", "timeout", "max-output", "max-import-bytes")
+	require.Equal(t, cfg, got)
+}
@@ -20,6 +20,9 @@
 // colon-separated environment variable where the colon in a URL would need to
 // be escaped.
 //
+// Netpath files can be verified against detached ed25519 or minisign
+// signatures using a SignatureVerifier with trusted keys for netpath prefixes.
+//
 // Config
 //
 // A type to encapsulate the configurable properties of a jsonnet VM and
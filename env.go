@@ -0,0 +1,193 @@
+package jsonnext
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"foxygo.at/s/errs"
+)
+
+// EnvPrefix is the prefix of the environment variables used by jnx to
+// populate a Config. See Config.LoadEnv.
+const EnvPrefix = "JNX_"
+
+// envVars maps the environment variable name infix for each kind of VMVar to
+// the constructor of that kind, with longer infixes first so that
+// EXT_STR_FILE_ is matched before EXT_STR_.
+var envVars = []struct { //nolint:gochecknoglobals
+	infix   string
+	ext     bool
+	makevar func(string) VMVar
+}{
+	{"EXT_STR_FILE_", true, NewExtStrFile},
+	{"EXT_CODE_FILE_", true, NewExtCodeFile},
+	{"TLA_STR_FILE_", false, NewTLAStrFile},
+	{"TLA_CODE_FILE_", false, NewTLACodeFile},
+	{"EXT_STR_", true, NewExtStr},
+	{"EXT_CODE_", true, NewExtCode},
+	{"TLA_STR_", false, NewTLAStr},
+	{"TLA_CODE_", false, NewTLACode},
+}
+
+// LoadEnv sets the fields of c from environment variables starting with
+// prefix, which is typically EnvPrefix. The variables are named after the
+// command line flags of the fields, upper-cased with hyphens replaced by
+// underscores:
+//  <prefix>JPATH: import path list, separated by filepath.ListSeparator
+//  <prefix>MAX_STACK: MaxStack
+//  <prefix>MAX_TRACE: MaxTrace
+//  <prefix>TIMEOUT: Timeout, as a duration such as "30s"
+//  <prefix>MAX_OUTPUT: MaxOutput
+//  <prefix>MAX_IMPORTS: MaxImports
+//  <prefix>MAX_IMPORT_BYTES: MaxImportBytes
+//  <prefix>EXT_STR_<name>: extVar <name> as a string
+//  <prefix>EXT_STR_FILE_<name>: extVar <name> as a string from a file
+//  <prefix>EXT_CODE_<name>: extVar <name> as code
+//  <prefix>EXT_CODE_FILE_<name>: extVar <name> as code from a file
+//  <prefix>TLA_STR_<name>: top-level arg <name> as a string
+//  <prefix>TLA_STR_FILE_<name>: top-level arg <name> as a string from a file
+//  <prefix>TLA_CODE_<name>: top-level arg <name> as code
+//  <prefix>TLA_CODE_FILE_<name>: top-level arg <name> as code from a file
+//
+// The var <name> is used as-is, so JNX_EXT_STR_env sets the extVar "env".
+// Since the longest match is used, a var whose name starts with "FILE_"
+// cannot be set as a literal string or code.
+//
+// As with ConfigFile.Apply, values already set in c take precedence over the
+// environment: VMVars are only set if a var of the same name is not already
+// set, import paths not already in c are appended and MaxStack, MaxTrace,
+// Timeout and the limits are only set if they have not already been set, as
+// described for MarkSet, and are then marked as set. When the same var name
+// is set by more than one environment variable of the same kind, such as
+// EXT_STR_<name> and EXT_STR_FILE_<name>, the first in sorted order is used.
+// This allows a Config to be populated from the command line first and then
+// have any gaps filled from the environment, and makes loading the same
+// environment twice harmless.
+//
+// An error is returned if MaxStack, MaxTrace or a limit is not a valid
+// integer or Timeout is not a valid duration. An error wrapping
+// ErrStrCodeClash is returned if a var is set as a string by one environment
+// variable and as code by another, such as EXT_STR_<name> and
+// EXT_CODE_<name>, as there is no order to pick one by.
+func (c *Config) LoadEnv(prefix string) error {
+	for _, f := range []struct {
+		p    *int
+		name string
+		def  int
+	}{
+		{&c.MaxStack, "max-stack", maxStackDepth},
+		{&c.MaxTrace, "max-trace", maxStackTraceOutput},
+		{&c.MaxOutput, "max-output", 0},
+		{&c.MaxImports, "max-imports", 0},
+		{&c.MaxImportBytes, "max-import-bytes", 0},
+	} {
+		if err := c.envInt(f.p, prefix, f.name, f.def); err != nil {
+			return err
+		}
+	}
+	if s, ok := os.LookupEnv(prefix + "TIMEOUT"); ok && !c.isSet("timeout", c.Timeout == 0) {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return errs.Errorf("invalid value for %sTIMEOUT: %v", prefix, err)
+		}
+		c.Timeout = d
+		c.MarkSet("timeout")
+	}
+	for _, p := range filepath.SplitList(os.Getenv(prefix + "JPATH")) {
+		if p != "" && !containsString(c.ImportPath, p) {
+			c.ImportPath = append(c.ImportPath, p)
+		}
+	}
+
+	if c.ExtVars == nil {
+		c.ExtVars = VMVarMap{}
+	}
+	if c.TLAVars == nil {
+		c.TLAVars = VMVarMap{}
+	}
+	environ := os.Environ()
+	sort.Strings(environ)
+	seen := map[string]string{}
+	for _, kv := range environ {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], prefix) {
+			continue
+		}
+		if err := c.setEnvVar(prefix, strings.TrimPrefix(parts[0], prefix), parts[1], seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setEnvVar sets a VMVar in c from an environment variable named name (with
+// prefix removed) if it is one of the VMVar kinds with a non-empty var
+// name. seen maps the vars set by the environment so far, by map and var
+// name, to the environment variable setting it, and is used to return an
+// error if a var is set as both a string and code.
+func (c *Config) setEnvVar(prefix, name, val string, seen map[string]string) error {
+	for _, ev := range envVars {
+		if !strings.HasPrefix(name, ev.infix) {
+			continue
+		}
+		key := strings.TrimPrefix(name, ev.infix)
+		if key == "" {
+			return nil
+		}
+		m := c.TLAVars
+		if ev.ext {
+			m = c.ExtVars
+		}
+		id := name[:len("EXT_")] + key
+		if prev, ok := seen[id]; ok {
+			if isCodeEnv(prev) != isCodeEnv(name) {
+				return errs.Errorf("%v: %s (%s and %s)", ErrStrCodeClash, key, prefix+prev, prefix+name)
+			}
+			return nil
+		}
+		seen[id] = name
+		if _, ok := m[key]; !ok {
+			m[key] = ev.makevar(val)
+		}
+		return nil
+	}
+	return nil
+}
+
+// isCodeEnv returns true if the environment variable name (with its prefix
+// removed) sets a VMVar as code rather than as a string.
+func isCodeEnv(name string) bool {
+	return strings.HasPrefix(name[len("EXT_"):], "CODE_")
+}
+
+// envInt sets the int field *p of c, set by the flag name and with the
+// default value def, from the environment variable named after the flag with
+// prefix, if it is set and the field has not already been set.
+func (c *Config) envInt(p *int, prefix, name string, def int) error {
+	env := prefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+	s, ok := os.LookupEnv(env)
+	if !ok || c.isSet(name, *p == def) {
+		return nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return errs.Errorf("invalid value for %s: %v", env, err)
+	}
+	*p = v
+	c.MarkSet(name)
+	return nil
+}
+
+// containsString returns true if ss contains s.
+func containsString(ss []string, s string) bool {
+	for _, e := range ss {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
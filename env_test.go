@@ -0,0 +1,88 @@
+package jsonnext
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"foxygo.at/s/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadEnv(t *testing.T) {
+	test.Env.Set("TEST_EXT_STR_FILE_x", "x.txt")
+	test.Env.Set("TEST_EXT_STR_FILE_", "ignored")
+	test.Env.Set("TEST_EXT_STR_FILE_y", "y.txt")
+	test.Env.Set("TEST_EXT_STR_y", "one")
+	test.Env.Set("TEST_TLA_CODE_y", "1")
+	test.Env.Set("TEST_TLA_STR_z", "z")
+	test.Env.Set("TEST_MAX_TRACE", "5")
+	test.Env.Set("TEST_MAX_IMPORTS", "10")
+	test.Env.Set("TEST_MAX_OUTPUT", "100")
+	test.Env.Set("TEST_JPATH", strings.Join([]string{"lib", "vendor"}, string(filepath.ListSeparator)))
+	defer test.Env.Restore()
+
+	c := NewConfig()
+	c.TLAVars["z"] = NewTLACode("'flag'")
+	c.MaxOutput = 200
+	c.ImportPath = []string{"vendor"}
+	err := c.LoadEnv("TEST_")
+	require.NoError(t, err)
+	// Loading the environment again changes nothing.
+	err = c.LoadEnv("TEST_")
+	require.NoError(t, err)
+
+	expected := NewConfig()
+	expected.ExtVars["x"] = NewExtStrFile("x.txt")
+	expected.ExtVars["y"] = NewExtStrFile("y.txt") // EXT_STR_FILE_y sorts before EXT_STR_y
+	expected.TLAVars["y"] = NewTLACode("1")
+	expected.TLAVars["z"] = NewTLACode("'flag'")
+	expected.MaxTrace = 5
+	expected.MaxImports = 10
+	expected.MaxOutput = 200
+	expected.ImportPath = []string{"vendor", "lib"}
+	expected.MarkSet("max-trace", "max-imports")
+	require.Equal(t, expected, c)
+}
+
+func TestLoadEnvNilMaps(t *testing.T) {
+	test.Env.Set("TEST_EXT_STR_x", "1")
+	test.Env.Set("TEST_TLA_CODE_y", "2")
+	defer test.Env.Restore()
+
+	c := &Config{}
+	err := c.LoadEnv("TEST_")
+	require.NoError(t, err)
+	require.Equal(t, VMVarMap{"x": NewExtStr("1")}, c.ExtVars)
+	require.Equal(t, VMVarMap{"y": NewTLACode("2")}, c.TLAVars)
+}
+
+func TestLoadEnvStrCodeClash(t *testing.T) {
+	test.Env.Set("TEST_EXT_CODE_y", "1")
+	test.Env.Set("TEST_EXT_STR_y", "one")
+	defer test.Env.Restore()
+
+	err := NewConfig().LoadEnv("TEST_")
+	require.Error(t, err)
+	require.True(t, errors.Is(err, ErrStrCodeClash))
+	require.Contains(t, err.Error(), "TEST_EXT_CODE_y and TEST_EXT_STR_y")
+
+	test.Env.Unset("TEST_EXT_STR_y")
+	test.Env.Set("TEST_EXT_STR_FILE_y", "y.txt")
+	err = NewConfig().LoadEnv("TEST_")
+	require.True(t, errors.Is(err, ErrStrCodeClash))
+}
+
+func TestLoadEnvErr(t *testing.T) {
+	test.Env.Set("TEST_MAX_STACK", "1k")
+	defer test.Env.Restore()
+
+	err := NewConfig().LoadEnv("TEST_")
+	require.Error(t, err)
+
+	test.Env.Unset("TEST_MAX_STACK")
+	test.Env.Set("TEST_MAX_IMPORT_BYTES", "1MB")
+	err = NewConfig().LoadEnv("TEST_")
+	require.Error(t, err)
+}
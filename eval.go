@@ -0,0 +1,426 @@
+package jsonnext
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"foxygo.at/s/errs"
+	jsonnet "github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrUnknownOutputMode is returned when evaluating with an OutputMode that is
+// not one of the defined modes.
+var ErrUnknownOutputMode = errors.New("unknown output mode")
+
+// ErrOutputFlags is returned by OutputFlags.Mode when more than one output
+// mode is selected.
+var ErrOutputFlags = errors.New("only one of --string, --yaml, --yaml-stream and --multi may be given")
+
+// ErrOutsideDir is returned by Output.WriteFiles for a filename that does not
+// resolve to a file inside the output directory, such as "../x.json".
+var ErrOutsideDir = errors.New("file not inside output dir")
+
+// OutputMode selects how an Evaluator produces output from the result of
+// evaluating jsonnet.
+type OutputMode int
+
+// Output modes of an Evaluator. They correspond to the output flags of the
+// standard jsonnet binary, with the addition of OutputYAML.
+const (
+	// OutputJSON outputs the result as a JSON document.
+	OutputJSON OutputMode = iota
+	// OutputString outputs the result, which must be a string, as is.
+	OutputString
+	// OutputMulti outputs each field of the result, which must be an
+	// object, as a JSON document in a file named by the field.
+	OutputMulti
+	// OutputYAML outputs the result as a YAML document.
+	OutputYAML
+	// OutputYAMLStream outputs each element of the result, which must be
+	// an array, as a YAML document in a stream of documents.
+	OutputYAMLStream
+)
+
+// TimeoutError is returned when an evaluation does not finish within the
+// Timeout of an Evaluator or the deadline of its context.
+type TimeoutError struct {
+	Timeout time.Duration // zero if the deadline came from the context
+	Err     error
+}
+
+// Error returns the error message of e.
+func (e *TimeoutError) Error() string {
+	if e.Timeout == 0 {
+		return "evaluation timed out: " + e.Err.Error()
+	}
+	return "evaluation timed out after " + e.Timeout.String()
+}
+
+// Unwrap returns the underlying context error, context.DeadlineExceeded.
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// Evaluator evaluates jsonnet files and snippets with a jsonnet VM and
+// produces output according to its Mode.
+//
+// The jsonnet VM cannot be interrupted, so when an evaluation times out or its
+// context is cancelled, the Evaluator returns immediately and abandons the
+// evaluation. Imports by the abandoned evaluation through Importer fail, so
+// it usually stops at its next import, but it may otherwise run until it
+// finishes. The Evaluator must not be used again after that.
+type Evaluator struct {
+	VM   *jsonnet.VM
+	Mode OutputMode
+
+	// Importer is the Importer of VM, if any. It is given the context of
+	// each evaluation so that imports can be cancelled, and its own Context
+	// is restored when the evaluation finishes. Its import cache and that
+	// of the VM are cleared before each evaluation, so that the import
+	// limits apply to each evaluation, and files are read again.
+	Importer *Importer
+
+	// Timeout limits the time taken by each evaluation. There is no limit
+	// if it is zero. jsonnet evaluation cannot be interrupted, so an
+	// evaluation that times out, or whose context is done, is abandoned
+	// rather than stopped: its goroutine keeps running, using the VM,
+	// until the evaluation finishes or fails at its next import, as
+	// imports fail once the context is done. The Evaluator should not be
+	// used for another evaluation after that.
+	Timeout time.Duration
+
+	// MaxOutput limits the total size in bytes of the output documents or
+	// files of each evaluation. A larger output fails with a *LimitError.
+	// There is no limit if it is zero. The size is checked after the
+	// output has been manifested in full, so MaxOutput keeps large outputs
+	// from being returned or written, but does not bound the memory or time
+	// used to produce them. Use Timeout for that.
+	MaxOutput int
+}
+
+// NewEvaluator returns an Evaluator with the given output mode, the Timeout
+// and MaxOutput of c and a VM and Importer made from c, as described for
+// Config.MakeVM.
+func NewEvaluator(c *Config, pathEnvVar string, mode OutputMode) *Evaluator {
+	vm, i := c.makeVM(pathEnvVar)
+	return &Evaluator{VM: vm, Mode: mode, Importer: i, Timeout: c.Timeout, MaxOutput: c.MaxOutput}
+}
+
+// Output is the result of an evaluation by an Evaluator.
+type Output struct {
+	// Docs holds the output documents, each ending in a newline. There is
+	// one document, or with OutputYAMLStream one for each element of the
+	// result, starting with a "---" document separator. Docs is empty
+	// with OutputMulti.
+	Docs []string
+
+	// Files maps filenames to their contents with OutputMulti.
+	Files map[string]string
+}
+
+// EvaluateFile evaluates the jsonnet file filename, which is imported with
+// the Importer of the VM so may be a netpath. If filename is empty or "-",
+// standard input is evaluated.
+func (e *Evaluator) EvaluateFile(filename string) (*Output, error) {
+	return e.EvaluateFileContext(context.Background(), filename)
+}
+
+// EvaluateFileContext is like EvaluateFile, but returns when ctx is done. If
+// the evaluation times out, a *TimeoutError is returned. If ctx is cancelled,
+// ctx.Err() is returned. The abandoned evaluation keeps running in its own
+// goroutine until it finishes, as described for Evaluator.Timeout.
+func (e *Evaluator) EvaluateFileContext(ctx context.Context, filename string) (*Output, error) {
+	return e.run(ctx, func() (ast.Node, error) {
+		node, _, err := e.VM.ImportAST("", filename)
+		return node, err
+	})
+}
+
+// EvaluateSnippet evaluates the jsonnet code in snippet. filename is used in
+// error messages and relative imports are relative to it.
+func (e *Evaluator) EvaluateSnippet(filename, snippet string) (*Output, error) {
+	return e.EvaluateSnippetContext(context.Background(), filename, snippet)
+}
+
+// EvaluateSnippetContext is like EvaluateSnippet, but stops when ctx is done,
+// as described for EvaluateFileContext.
+func (e *Evaluator) EvaluateSnippetContext(ctx context.Context, filename, snippet string) (*Output, error) {
+	return e.run(ctx, func() (ast.Node, error) {
+		return jsonnet.SnippetToAST(filename, snippet)
+	})
+}
+
+type evalResult struct {
+	out *Output
+	err error
+}
+
+// run parses and evaluates jsonnet in a goroutine, returning early if ctx is
+// done or the Timeout of e expires. The import limits of the Importer are
+// reset for the evaluation, and a *LimitError is returned if any limit is
+// exceeded. The import caches of the Importer and the VM are cleared so that
+// every file imported by the evaluation is counted.
+//
+// The Importer is given ctx for the evaluation, and its previous Context is
+// restored once the evaluation has finished. An abandoned evaluation keeps
+// the done ctx so that its later imports fail.
+func (e *Evaluator) run(parent context.Context, parse func() (ast.Node, error)) (*Output, error) {
+	ctx := parent
+	if e.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.Timeout)
+		defer cancel()
+	}
+	finished := false
+	if e.Importer != nil {
+		prev := e.Importer.Context
+		e.Importer.Context = ctx
+		e.Importer.resetUsage()
+		// Files cached by an earlier evaluation would not be read, so
+		// not counted. Setting the Importer gives the VM a new cache.
+		e.Importer.ClearCache()
+		e.VM.Importer(e.Importer)
+		defer func() {
+			if finished {
+				e.Importer.Context = prev
+			}
+		}()
+	}
+
+	ch := make(chan evalResult, 1)
+	go func() {
+		node, err := parse()
+		if err != nil {
+			ch <- evalResult{err: err}
+			return
+		}
+		out, err := e.evaluate(node)
+		if err == nil {
+			// A post-hoc check: the VM cannot stop manifesting early.
+			err = checkOutput(out, e.MaxOutput)
+		}
+		ch <- evalResult{out: out, err: err}
+	}()
+
+	select {
+	case r := <-ch:
+		finished = true
+		if r.err != nil && ctx.Err() != nil {
+			// An import failed because ctx is done.
+			return nil, e.ctxErr(parent, ctx)
+		}
+		if r.err != nil && e.Importer != nil && e.Importer.limitErr != nil {
+			// jsonnet does not preserve the type of import errors.
+			return nil, e.Importer.limitErr
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		return r.out, nil
+	case <-ctx.Done():
+		return nil, e.ctxErr(parent, ctx)
+	}
+}
+
+// ctxErr returns the error for the done context ctx, derived from parent with
+// the Timeout of e.
+func (e *Evaluator) ctxErr(parent, ctx context.Context) error {
+	err := ctx.Err()
+	switch {
+	case parent.Err() == nil:
+		return &TimeoutError{Timeout: e.Timeout, Err: err}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &TimeoutError{Err: err}
+	}
+	return err
+}
+
+func (e *Evaluator) evaluate(node ast.Node) (*Output, error) {
+	e.VM.StringOutput = e.Mode == OutputString
+	switch e.Mode {
+	case OutputJSON, OutputString:
+		out, err := e.VM.Evaluate(node)
+		if err != nil {
+			return nil, err
+		}
+		return &Output{Docs: []string{out}}, nil
+	case OutputMulti:
+		files, err := e.VM.EvaluateMulti(node)
+		if err != nil {
+			return nil, err
+		}
+		return &Output{Files: files}, nil
+	case OutputYAML:
+		out, err := e.VM.Evaluate(node)
+		if err != nil {
+			return nil, err
+		}
+		doc, err := jsonToYAML(out)
+		if err != nil {
+			return nil, err
+		}
+		return &Output{Docs: []string{doc}}, nil
+	case OutputYAMLStream:
+		outs, err := e.VM.EvaluateStream(node)
+		if err != nil {
+			return nil, err
+		}
+		docs := make([]string, len(outs))
+		for i, out := range outs {
+			doc, err := jsonToYAML(out)
+			if err != nil {
+				return nil, err
+			}
+			docs[i] = "---\n" + doc
+		}
+		return &Output{Docs: docs}, nil
+	}
+	return nil, errs.Errorf("%v: %d", ErrUnknownOutputMode, e.Mode)
+}
+
+// String returns the documents of o concatenated.
+func (o *Output) String() string {
+	return strings.Join(o.Docs, "")
+}
+
+// Write writes the documents of o to w.
+func (o *Output) Write(w io.Writer) error {
+	_, err := io.WriteString(w, o.String())
+	return err
+}
+
+// WriteFiles writes the files of o into dir, creating any directories needed,
+// and returns the paths of the files written in sorted order. Unlike the
+// jsonnet binary, which writes files with names such as "../x.json" outside
+// its output directory, an error wrapping ErrOutsideDir is returned before
+// any file is written if a filename does not resolve to a file inside dir.
+func (o *Output) WriteFiles(dir string) ([]string, error) {
+	names := make([]string, 0, len(o.Files))
+	for name := range o.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	paths := make([]string, 0, len(names))
+	for _, name := range names {
+		p, err := outputPath(dir, name)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, p)
+	}
+	for i, p := range paths {
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil { //nolint:gomnd
+			return paths[:i], err
+		}
+		if err := ioutil.WriteFile(p, []byte(o.Files[names[i]]), 0o666); err != nil { //nolint:gomnd,gosec
+			return paths[:i], err
+		}
+	}
+	return paths, nil
+}
+
+// outputPath returns the path of the output file name in dir, or an error
+// wrapping ErrOutsideDir if it is dir itself or outside it.
+func outputPath(dir, name string) (string, error) {
+	p := filepath.Join(dir, filepath.FromSlash(name))
+	rel, err := filepath.Rel(filepath.Clean(dir), p)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errs.Errorf("%v: %#v", ErrOutsideDir, name)
+	}
+	return p, nil
+}
+
+// OutputFlags holds the output flags of a CLI evaluating jsonnet, such as
+// jnx, which select the OutputMode of its Evaluator. It has kong tags so it
+// can be embedded in a kong CLI struct.
+type OutputFlags struct {
+	String     bool   `short:"S" help:"Expect a string result and output it as is"`
+	YAML       bool   `name:"yaml" help:"Output YAML instead of JSON"`
+	YAMLStream bool   `name:"yaml-stream" short:"y" help:"Output the elements of an array result as a stream of YAML documents"`
+	Multi      string `short:"m" placeholder:"dir" help:"Write each field of an object result to a JSON file in dir"`
+}
+
+// Mode returns the output mode selected by o, OutputJSON if none is.
+func (o OutputFlags) Mode() (OutputMode, error) {
+	mode, n := OutputJSON, 0
+	for m, set := range map[OutputMode]bool{
+		OutputString:     o.String,
+		OutputYAML:       o.YAML,
+		OutputYAMLStream: o.YAMLStream,
+		OutputMulti:      o.Multi != "",
+	} {
+		if set {
+			mode, n = m, n+1
+		}
+	}
+	if n > 1 {
+		return mode, ErrOutputFlags
+	}
+	return mode, nil
+}
+
+// Run evaluates filename with an Evaluator made from c and pathEnvVar in the
+// output mode selected by o, as for EvaluateFile. The output is written to w,
+// or with OutputMulti the files are written to the Multi dir of o and their
+// paths to w, one per line.
+func (o OutputFlags) Run(c *Config, pathEnvVar, filename string, w io.Writer) error {
+	mode, err := o.Mode()
+	if err != nil {
+		return err
+	}
+	out, err := NewEvaluator(c, pathEnvVar, mode).EvaluateFile(filename)
+	if err != nil {
+		return err
+	}
+	if mode != OutputMulti {
+		return out.Write(w)
+	}
+	paths, err := out.WriteFiles(o.Multi)
+	for _, p := range paths {
+		if _, werr := fmt.Fprintln(w, p); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return err
+}
+
+// jsonToYAML converts the JSON document s into a YAML document in block
+// style, keeping the order of object fields.
+func jsonToYAML(s string) (string, error) {
+	var n yaml.Node
+	if err := yaml.Unmarshal([]byte(s), &n); err != nil {
+		return "", err
+	}
+	resetStyle(&n)
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2) //nolint:gomnd
+	if err := enc.Encode(&n); err != nil {
+		return "", err
+	}
+	if err := enc.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// resetStyle clears the flow and quoting styles of n and its descendants so
+// the YAML encoder chooses them.
+func resetStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, c := range n.Content {
+		resetStyle(c)
+	}
+}
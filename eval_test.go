@@ -0,0 +1,235 @@
+package jsonnext
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvaluator(t *testing.T) {
+	tests := map[string]struct {
+		mode     OutputMode
+		snippet  string
+		expected string
+	}{
+		"json":        {OutputJSON, `{ a: 1, b: ["x"] }`, "{\n   \"a\": 1,\n   \"b\": [\n      \"x\"\n   ]\n}\n"},
+		"string":      {OutputString, `"hello " + std.extVar("who")`, "hello world\n"},
+		"yaml":        {OutputYAML, `{ a: 1, b: ["x", "true"] }`, "a: 1\nb:\n  - x\n  - \"true\"\n"},
+		"yaml-stream": {OutputYAMLStream, `[{ a: 1 }, "x"]`, "---\na: 1\n---\nx\n"},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			c := NewConfig()
+			c.ExtVars["who"] = NewExtStr("world")
+			e := NewEvaluator(c, "", tc.mode)
+			out, err := e.EvaluateSnippet("test.jsonnet", tc.snippet)
+			require.NoError(t, err)
+			var buf bytes.Buffer
+			require.NoError(t, out.Write(&buf))
+			require.Equal(t, tc.expected, buf.String())
+		})
+	}
+}
+
+func TestEvaluatorFile(t *testing.T) {
+	e := NewEvaluator(NewConfig(), "", OutputString)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e.Importer.Context = ctx
+	out, err := e.EvaluateFile("testdata/importer/hello.txt")
+	require.Error(t, err) // plain text is not valid jsonnet
+	require.Nil(t, out)
+	require.Equal(t, ctx, e.Importer.Context)
+
+	e.Mode = OutputMode(-1)
+	_, err = e.EvaluateSnippet("test.jsonnet", "1")
+	require.True(t, errors.Is(err, ErrUnknownOutputMode))
+}
+
+func TestEvaluatorMulti(t *testing.T) {
+	e := NewEvaluator(NewConfig(), "", OutputMulti)
+	out, err := e.EvaluateSnippet("test.jsonnet", `{ "a.json": { x: 1 }, "sub/b.json": 2 }`)
+	require.NoError(t, err)
+	expected := map[string]string{
+		"a.json":     "{\n   \"x\": 1\n}\n",
+		"sub/b.json": "2\n",
+	}
+	require.Equal(t, expected, out.Files)
+	require.Equal(t, "", out.String())
+
+	dir, err := ioutil.TempDir("", "jnx-eval-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) //nolint:errcheck
+
+	paths, err := out.WriteFiles(dir)
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(dir, "a.json"), filepath.Join(dir, "sub", "b.json")}, paths)
+	b, err := ioutil.ReadFile(paths[1])
+	require.NoError(t, err)
+	require.Equal(t, "2\n", string(b))
+}
+
+func TestWriteFilesOutsideDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jnx-eval-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) //nolint:errcheck
+	out := filepath.Join(dir, "out")
+
+	for _, name := range []string{"../x.json", "sub/../../x.json", "..", ".", ""} {
+		o := &Output{Files: map[string]string{"a.json": "1\n", name: "2\n"}}
+		paths, err := o.WriteFiles(out)
+		require.True(t, errors.Is(err, ErrOutsideDir), "%q: %v", name, err)
+		require.Empty(t, paths)
+	}
+	// Nothing is written, not even the files inside the dir.
+	_, err = os.Stat(out)
+	require.True(t, os.IsNotExist(err), "%v", err)
+
+	o := &Output{Files: map[string]string{"sub/../a.json": "1\n", "/b.json": "2\n"}}
+	paths, err := o.WriteFiles(out)
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(out, "b.json"), filepath.Join(out, "a.json")}, paths)
+}
+
+func TestOutputFlags(t *testing.T) {
+	tests := map[string]struct {
+		flags    OutputFlags
+		expected OutputMode
+	}{
+		"json":        {OutputFlags{}, OutputJSON},
+		"string":      {OutputFlags{String: true}, OutputString},
+		"yaml":        {OutputFlags{YAML: true}, OutputYAML},
+		"yaml-stream": {OutputFlags{YAMLStream: true}, OutputYAMLStream},
+		"multi":       {OutputFlags{Multi: "out"}, OutputMulti},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			mode, err := tc.flags.Mode()
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, mode)
+		})
+	}
+
+	o := OutputFlags{YAML: true, Multi: "out"}
+	_, err := o.Mode()
+	require.True(t, errors.Is(err, ErrOutputFlags), "%v", err)
+	var buf bytes.Buffer
+	err = o.Run(NewConfig(), "", "testdata/importer/hello.txt", &buf)
+	require.True(t, errors.Is(err, ErrOutputFlags), "%v", err)
+	require.Equal(t, "", buf.String())
+}
+
+func TestJSONToYAML(t *testing.T) {
+	tests := map[string]string{
+		"{}":                             "{}\n",
+		`{"a": {"b": [1, 2]}, "c": "x"}`: "a:\n  b:\n    - 1\n    - 2\nc: x\n",
+		`"multi\nline\n"`:                "|\n  multi\n  line\n",
+		`["1", null, 1.5]`:               "- \"1\"\n- null\n- 1.5\n",
+	}
+	for input, expected := range tests {
+		got, err := jsonToYAML(input)
+		require.NoError(t, err)
+		require.Equal(t, expected, got, input)
+	}
+}
+
+func TestEvaluatorTimeout(t *testing.T) {
+	cancelled := make(chan struct{})
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		close(cancelled)
+	}))
+	defer srv.Close()
+
+	c := NewConfig()
+	c.Timeout = 50 * time.Millisecond
+	e := NewEvaluator(c, "", OutputJSON)
+	e.Importer.Fetcher = srv.Client()
+	netpath := strings.TrimPrefix(srv.URL, "https:") + "/slow.jsonnet"
+
+	_, err := e.EvaluateFile(netpath)
+	var terr *TimeoutError
+	require.True(t, errors.As(err, &terr), "%v", err)
+	require.Equal(t, c.Timeout, terr.Timeout)
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("fetch was not cancelled")
+	}
+
+	// Imports fail once the context is done.
+	_, _, err = e.Importer.Import("", "testdata/importer/hello.txt")
+	require.True(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+}
+
+func TestEvaluatorContext(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	netpath := strings.TrimPrefix(srv.URL, "https:") + "/slow.jsonnet"
+
+	e := NewEvaluator(NewConfig(), "", OutputJSON)
+	e.Importer.Fetcher = srv.Client()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := e.EvaluateFileContext(ctx, netpath)
+	var terr *TimeoutError
+	require.True(t, errors.As(err, &terr), "%v", err)
+	require.Equal(t, time.Duration(0), terr.Timeout)
+
+	e = NewEvaluator(NewConfig(), "", OutputJSON)
+	e.Importer.Fetcher = srv.Client()
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err = e.EvaluateFileContext(ctx, netpath)
+	require.True(t, errors.Is(err, context.Canceled), "%v", err)
+	require.False(t, errors.As(err, &terr))
+}
+
+func TestEvaluatorLimits(t *testing.T) {
+	c := NewConfig()
+	c.MaxOutput = 5
+	e := NewEvaluator(c, "", OutputString)
+	_, err := e.EvaluateSnippet("test.jsonnet", `"hello world"`)
+	var lerr *LimitError
+	require.True(t, errors.As(err, &lerr), "%v", err)
+	require.True(t, errors.Is(err, ErrOutputLimit))
+	require.Equal(t, 5, lerr.Limit)
+
+	c = NewConfig()
+	c.MaxImports = 1
+	e = NewEvaluator(c, "", OutputString)
+	_, err = e.EvaluateSnippet("test.jsonnet", `importstr "testdata/importer/hello.txt" + importstr "testdata/importer/mellow.txt"`)
+	require.True(t, errors.Is(err, ErrImportLimit), "%v", err)
+
+	// Imports are counted for each evaluation.
+	out, err := e.EvaluateSnippet("test.jsonnet", `importstr "testdata/importer/mellow.txt"`)
+	require.NoError(t, err)
+	require.Equal(t, "mellow world\n\n", out.String())
+
+	// Files imported by an earlier evaluation are counted again.
+	_, err = e.EvaluateSnippet("test.jsonnet", `importstr "testdata/importer/mellow.txt" + importstr "testdata/importer/hello.txt"`)
+	require.True(t, errors.Is(err, ErrImportLimit), "%v", err)
+
+	c = NewConfig()
+	c.MaxImportBytes = 20
+	e = NewEvaluator(c, "", OutputString)
+	_, err = e.EvaluateSnippet("test.jsonnet", `importstr "testdata/importer/mellow.txt"`)
+	require.NoError(t, err)
+	_, err = e.EvaluateSnippet("test.jsonnet", `importstr "testdata/importer/mellow.txt" + importstr "testdata/importer/hello.txt"`)
+	require.True(t, errors.Is(err, ErrImportBytesLimit), "%v", err)
+}
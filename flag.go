@@ -3,6 +3,10 @@ package jsonnext
 import (
 	"flag"
 	"fmt"
+	"strconv"
+	"time"
+
+	"foxygo.at/s/errs"
 )
 
 // ConfigFlags defines a set of flags in the given FlagSet for a Config struct
@@ -16,39 +20,181 @@ import (
 //   -ext-code: ext var as code literal
 //   -ext-str-file: ext var as string from file
 //   -ext-code-file: ext var as code from file
+//   -ext-vars-file: ext vars from the fields of a JSON or YAML file
+//   -ext-str-env-prefix: ext vars as strings from environment variables
+//   -ext-str-secret: secret ext var as string from file, fd or environment
+//   -ext-code-secret: secret ext var as code from file, fd or environment
+//   -ext-str-cmd: ext var as string from the output of a command
+//   -O, -ext-obj: field of an ext var object from a dotted path
 //  Config.TLAVars:
 //   -A, -tla-str: top-level arg as string literal
 //   -tla-code: top-level arg as code literal
 //   -tla-str-file: top-level arg as string from file
 //   -tla-code-file: top-level arg as code from file
-func ConfigFlags(fs *flag.FlagSet) *Config {
+//   -tla-vars-file: top-level args from the fields of a JSON or YAML file
+//   -tla-str-secret: secret top-level arg as string from file, fd or environment
+//   -tla-code-secret: secret top-level arg as code from file, fd or environment
+//   -tla-str-cmd: top-level arg as string from the output of a command
+//  Config.MaxStack:
+//   -max-stack
+//  Config.MaxTrace:
+//   -max-trace
+//  Config.Timeout:
+//   -timeout
+//  Config.MaxOutput:
+//   -max-output
+//  Config.MaxImports:
+//   -max-imports
+//  Config.MaxImportBytes:
+//   -max-import-bytes
+//
+// The options opts change the set of flags defined and their names and usage.
+// See FlagOption.
+func ConfigFlags(fs *flag.FlagSet, opts ...FlagOption) *Config {
 	c := NewConfig()
-	ConfigFlagsVar(fs, c)
+	ConfigFlagsVar(fs, c, opts...)
 	return c
 }
 
 // ConfigFlagsVar defines a set of flags in the given FlagSet for a Config
 // struct to populate the fields from the command line. The argument c points
 // to the Config struct to populate. The set of flags defined is described in
-// the ConfigFlags function description.
-func ConfigFlagsVar(fs *flag.FlagSet, c *Config) {
-	StringSliceVar(fs, &c.ImportPath, "jpath", "Add a library search `dir`")
-	ExtStrVar(fs, c.ExtVars, "ext-str", "Add extVar `var[=str]` (from environment if <str> is omitted)")
-	ExtCodeVar(fs, c.ExtVars, "ext-code", "Add extVar `var[=code]` (from environment if <code> is omitted)")
-	ExtStrFileVar(fs, c.ExtVars, "ext-str-file", "Add extVar `var=file` string from a file")
-	ExtCodeFileVar(fs, c.ExtVars, "ext-code-file", "Add extVar `var=file` code from a file")
-
-	TLAStrVar(fs, c.TLAVars, "tla-str", "Add top-level arg `var=[=str]` (from environment if <str> is omitted)")
-	TLACodeVar(fs, c.TLAVars, "tla-code", "Add top-level arg `var[=code]` (from environment if <code> is omitted)")
-	TLAStrFileVar(fs, c.TLAVars, "tla-str-file", "Add top-level arg `var=file` string from a file")
-	TLACodeFileVar(fs, c.TLAVars, "tla-code-file", "Add top-level arg `var=file` code from a file")
-	fs.IntVar(&c.MaxStack, "max-stack", 500, "Number of allowed stack frames of jsonnet VM")
-	fs.IntVar(&c.MaxTrace, "max-trace", 20, "Maximum number of stack frames output on error")
-
-	// Add short flags. TODO(camh): consider making these optional.
-	StringSliceVar(fs, &c.ImportPath, "J", "Add a library search `dir`")
-	ExtStrVar(fs, c.ExtVars, "V", "Add extVar `var[=str]` (from environment if <str> is omitted)")
-	TLAStrVar(fs, c.TLAVars, "A", "Add top-level arg `var[=str]` (from environment if <str> is omitted)")
+// the ConfigFlags function description, changed by the options opts.
+//
+// The flag package has no hook to run after parsing, so to fill fields not
+// set on the command line from the environment, call
+// c.LoadEnv(EnvPrefix) after the FlagSet has been parsed.
+func ConfigFlagsVar(fs *flag.FlagSet, c *Config, opts ...FlagOption) {
+	o := newFlagOptions(opts)
+	if o.groups&FlagsImportPath != 0 {
+		StringSliceVar(fs, &c.ImportPath, o.name("jpath"), o.usage("jpath", "Add a library search `dir`"))
+	}
+	if o.groups&FlagsExtVars != 0 {
+		ExtStrVar(fs, c.ExtVars, o.name("ext-str"), o.usage("ext-str", "Add extVar `var[=str]` (from environment if <str> is omitted)"))
+		ExtCodeVar(fs, c.ExtVars, o.name("ext-code"), o.usage("ext-code", "Add extVar `var[=code]` (from environment if <code> is omitted)"))
+		ExtStrFileVar(fs, c.ExtVars, o.name("ext-str-file"), o.usage("ext-str-file", "Add extVar `var=file` string from a file"))
+		ExtCodeFileVar(fs, c.ExtVars, o.name("ext-code-file"), o.usage("ext-code-file", "Add extVar `var=file` code from a file"))
+		ExtVarsFileVar(fs, c.ExtVars, o.name("ext-vars-file"), o.usage("ext-vars-file", "Add extVars from the fields of a JSON or YAML `file`"))
+		ExtStrEnvPrefixVar(fs, c.ExtVars, o.name("ext-str-env-prefix"), o.usage("ext-str-env-prefix", "Add extVar strings from environment variables starting with `prefix`, with it removed"))
+		ExtStrSecretVar(fs, c.ExtVars, o.name("ext-str-secret"), o.usage("ext-str-secret", "Add secret extVar `var[=file]` string from a file or fd:N (from environment if <file> is omitted)"))
+		ExtCodeSecretVar(fs, c.ExtVars, o.name("ext-code-secret"), o.usage("ext-code-secret", "Add secret extVar `var[=file]` code from a file or fd:N (from environment if <file> is omitted)"))
+		ExtStrCmdVar(fs, c, o.name("ext-str-cmd"), o.usage("ext-str-cmd", "Add extVar `var=command` string from the output of a command"))
+		ExtObjVar(fs, c.ExtVars, o.name("ext-obj"), o.usage("ext-obj", "Set field of extVar object `var.field=value` (quote value to force a string)"))
+	}
+	if o.groups&FlagsTLAVars != 0 {
+		TLAStrVar(fs, c.TLAVars, o.name("tla-str"), o.usage("tla-str", "Add top-level arg `var=[=str]` (from environment if <str> is omitted)"))
+		TLACodeVar(fs, c.TLAVars, o.name("tla-code"), o.usage("tla-code", "Add top-level arg `var[=code]` (from environment if <code> is omitted)"))
+		TLAStrFileVar(fs, c.TLAVars, o.name("tla-str-file"), o.usage("tla-str-file", "Add top-level arg `var=file` string from a file"))
+		TLACodeFileVar(fs, c.TLAVars, o.name("tla-code-file"), o.usage("tla-code-file", "Add top-level arg `var=file` code from a file"))
+		TLAVarsFileVar(fs, c.TLAVars, o.name("tla-vars-file"), o.usage("tla-vars-file", "Add top-level args from the fields of a JSON or YAML `file`"))
+		TLAStrSecretVar(fs, c.TLAVars, o.name("tla-str-secret"), o.usage("tla-str-secret", "Add secret top-level arg `var[=file]` string from a file or fd:N (from environment if <file> is omitted)"))
+		TLACodeSecretVar(fs, c.TLAVars, o.name("tla-code-secret"), o.usage("tla-code-secret", "Add secret top-level arg `var[=file]` code from a file or fd:N (from environment if <file> is omitted)"))
+		TLAStrCmdVar(fs, c, o.name("tla-str-cmd"), o.usage("tla-str-cmd", "Add top-level arg `var=command` string from the output of a command"))
+	}
+	if o.groups&FlagsVM != 0 {
+		fs.Var(&intValue{&c.MaxStack, c, "max-stack"}, o.name("max-stack"), o.usage("max-stack", "Number of allowed stack frames of jsonnet VM"))
+		fs.Var(&intValue{&c.MaxTrace, c, "max-trace"}, o.name("max-trace"), o.usage("max-trace", "Maximum number of stack frames output on error"))
+		fs.Var(&durationValue{&c.Timeout, c, "timeout"}, o.name("timeout"), o.usage("timeout", "Maximum time to evaluate for, such as 30s (no limit if 0)"))
+		fs.Var(&intValue{&c.MaxOutput, c, "max-output"}, o.name("max-output"), o.usage("max-output", "Maximum size of the output in bytes (no limit if 0)"))
+		fs.Var(&intValue{&c.MaxImports, c, "max-imports"}, o.name("max-imports"), o.usage("max-imports", "Maximum number of files imported (no limit if 0)"))
+		fs.Var(&intValue{&c.MaxImportBytes, c, "max-import-bytes"}, o.name("max-import-bytes"), o.usage("max-import-bytes", "Maximum total size of files imported in bytes (no limit if 0)"))
+	}
+
+	// Add short flags, with the usage of their long flags.
+	if o.noShort {
+		return
+	}
+	if o.groups&FlagsImportPath != 0 {
+		StringSliceVar(fs, &c.ImportPath, o.name("J"), o.usage("jpath", "Add a library search `dir`"))
+	}
+	if o.groups&FlagsExtVars != 0 {
+		ExtStrVar(fs, c.ExtVars, o.name("V"), o.usage("ext-str", "Add extVar `var[=str]` (from environment if <str> is omitted)"))
+		ExtObjVar(fs, c.ExtVars, o.name("O"), o.usage("ext-obj", "Set field of extVar object `var.field=value` (quote value to force a string)"))
+	}
+	if o.groups&FlagsTLAVars != 0 {
+		TLAStrVar(fs, c.TLAVars, o.name("A"), o.usage("tla-str", "Add top-level arg `var[=str]` (from environment if <str> is omitted)"))
+	}
+}
+
+// FlagGroup is a set of groups of the flags defined by ConfigFlagsVar, for
+// selecting which groups to define with WithFlagGroups.
+type FlagGroup int
+
+// Groups of the flags defined by ConfigFlagsVar.
+const (
+	// FlagsImportPath is the -jpath flag.
+	FlagsImportPath FlagGroup = 1 << iota
+	// FlagsExtVars are the -ext-* flags.
+	FlagsExtVars
+	// FlagsTLAVars are the -tla-* flags.
+	FlagsTLAVars
+	// FlagsVM are the -max-stack, -max-trace, -timeout and -max-*
+	// limit flags.
+	FlagsVM
+
+	// FlagsAll is all of the groups of flags.
+	FlagsAll = FlagsImportPath | FlagsExtVars | FlagsTLAVars | FlagsVM
+)
+
+// FlagOption is an option for ConfigFlags and ConfigFlagsVar to change the
+// flags they define, so they can be added to a FlagSet that already has
+// flags of its own.
+type FlagOption func(*flagOptions)
+
+type flagOptions struct {
+	prefix  string
+	noShort bool
+	groups  FlagGroup
+	usages  map[string]string
+}
+
+func newFlagOptions(opts []FlagOption) *flagOptions {
+	o := &flagOptions{groups: FlagsAll, usages: map[string]string{}}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// name returns the name of the flag name with the prefix added.
+func (o *flagOptions) name(name string) string {
+	return o.prefix + name
+}
+
+// usage returns the usage of the long flag name, or def if it has not been
+// overridden.
+func (o *flagOptions) usage(name, def string) string {
+	if u, ok := o.usages[name]; ok {
+		return u
+	}
+	return def
+}
+
+// WithFlagPrefix adds prefix to the names of all the flags, including the
+// short flags. For example, with the prefix "jsonnet." the -ext-str flag is
+// named -jsonnet.ext-str and -V is named -jsonnet.V.
+func WithFlagPrefix(prefix string) FlagOption {
+	return func(o *flagOptions) { o.prefix = prefix }
+}
+
+// WithoutShortFlags leaves out the short flags -J, -V, -A and -O, leaving
+// only their long flags -jpath, -ext-str, -tla-str and -ext-obj.
+func WithoutShortFlags() FlagOption {
+	return func(o *flagOptions) { o.noShort = true }
+}
+
+// WithFlagGroups defines only the flags in groups, such as
+// FlagsImportPath|FlagsExtVars, instead of all of them.
+func WithFlagGroups(groups FlagGroup) FlagOption {
+	return func(o *flagOptions) { o.groups = groups }
+}
+
+// WithFlagUsage replaces the usage string of the flag with the long name
+// name, given without any prefix, and of its short flag if it has one. A
+// back-quoted name in usage is used as the name of the flag's value, as in
+// the flag package.
+func WithFlagUsage(name, usage string) FlagOption {
+	return func(o *flagOptions) { o.usages[name] = usage }
 }
 
 // StringSliceVar defines a flag in the given FlagSet with the given name and
@@ -79,6 +225,66 @@ func (s *stringSliceValue) Set(v string) error {
 func (s *stringSliceValue) Get() interface{} { return []string(*s) }
 func (s *stringSliceValue) String() string   { return fmt.Sprint(*s) }
 
+// intValue is a flag.Value for an int field of a Config that marks the field
+// as set with Config.MarkSet when the flag is given, so that it takes
+// precedence over the environment and config files even if it is given its
+// default value.
+type intValue struct {
+	p    *int
+	c    *Config
+	name string
+}
+
+func (v *intValue) Set(s string) error {
+	i, err := strconv.ParseInt(s, 0, strconv.IntSize)
+	if err != nil {
+		return errs.Errorf("%v: %v", ErrInvalidValue, err)
+	}
+	*v.p = int(i)
+	v.c.MarkSet(v.name)
+	return nil
+}
+
+func (v *intValue) Get() interface{} { return *v.p }
+
+// String returns the value of v. It handles a zero intValue, which the flag
+// package creates to find the zero value of the flag.
+func (v *intValue) String() string {
+	if v.p == nil {
+		return "0"
+	}
+	return strconv.Itoa(*v.p)
+}
+
+// durationValue is a flag.Value for a time.Duration field of a Config that
+// marks the field as set, as for intValue.
+type durationValue struct {
+	p    *time.Duration
+	c    *Config
+	name string
+}
+
+func (v *durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return errs.Errorf("%v: %v", ErrInvalidValue, err)
+	}
+	*v.p = d
+	v.c.MarkSet(v.name)
+	return nil
+}
+
+func (v *durationValue) Get() interface{} { return *v.p }
+
+// String returns the value of v, handling a zero durationValue as for
+// intValue.
+func (v *durationValue) String() string {
+	if v.p == nil {
+		return "0s"
+	}
+	return v.p.String()
+}
+
 // ExtStrVar defines flag with the given name and usage string in the given
 // FlagSet to set a VMVar in the given VMVarMap. The VMVar sets an extVar
 // string literal in a jsonnet VM.
@@ -199,6 +405,146 @@ func TLACodeFileVar(fs *flag.FlagSet, m VMVarMap, name, usage string) {
 	fs.Var(vmVarMapValue{m, NewTLACodeFile}, name, usage)
 }
 
+// ExtVarsFileVar defines flag with the given name and usage string in the
+// given FlagSet to set VMVars in the given VMVarMap. The VMVars set extVars
+// in a jsonnet VM.
+//
+// The flag value on the command line is the name of a JSON or YAML file
+// containing an object. Each top-level field of the object sets an extVar
+// named by the field, as a string literal if its value is a string and as a
+// code literal otherwise. See VMVarMap.SetVarsFromFile.
+//
+// The flag can be repeated multiple times on the command line. Each instance
+// adds new values to the given VMVarMap.
+func ExtVarsFileVar(fs *flag.FlagSet, m VMVarMap, name, usage string) {
+	fs.Var(vmVarsFileValue{m, NewExtStr, NewExtCode}, name, usage)
+}
+
+// TLAVarsFileVar defines flag with the given name and usage string in the
+// given FlagSet to set VMVars in the given VMVarMap. The VMVars set top-level
+// args in a jsonnet VM.
+//
+// The flag value on the command line is the name of a JSON or YAML file
+// containing an object. Each top-level field of the object sets a top-level
+// arg named by the field, as a string literal if its value is a string and as
+// a code literal otherwise. See VMVarMap.SetVarsFromFile.
+//
+// The flag can be repeated multiple times on the command line. Each instance
+// adds new values to the given VMVarMap.
+func TLAVarsFileVar(fs *flag.FlagSet, m VMVarMap, name, usage string) {
+	fs.Var(vmVarsFileValue{m, NewTLAStr, NewTLACode}, name, usage)
+}
+
+// ExtStrEnvPrefixVar defines flag with the given name and usage string in the
+// given FlagSet to set VMVars in the given VMVarMap. The VMVars set extVar
+// string literals in a jsonnet VM.
+//
+// The flag value on the command line is a prefix. Each environment variable
+// whose name starts with the prefix sets an extVar named by the rest of the
+// environment variable name. See VMVarMap.SetVarsFromEnv.
+//
+// The flag can be repeated multiple times on the command line. Each instance
+// adds new values to the given VMVarMap.
+func ExtStrEnvPrefixVar(fs *flag.FlagSet, m VMVarMap, name, usage string) {
+	fs.Var(vmVarEnvValue{m, NewExtStr}, name, usage)
+}
+
+// ExtStrSecretVar defines flag with the given name and usage string in the
+// given FlagSet to set a secret VMVar in the given VMVarMap. The VMVar sets an
+// extVar string literal in a jsonnet VM and its value is redacted everywhere
+// else (see NewSecret).
+//
+// The flag value on the command line is parsed as "key[=source]", where
+// source is a file or "fd:N" for file descriptor N to read the string from.
+// If "=source" is omitted then key is looked up in the environment for the
+// value. See VMVarMap.SetSecretVar.
+//
+// The flag can be repeated multiple times on the command line. Each instance
+// adds a new value to the given VMVarMap.
+func ExtStrSecretVar(fs *flag.FlagSet, m VMVarMap, name, usage string) {
+	fs.Var(vmSecretVarMapValue{m, NewExtStr}, name, usage)
+}
+
+// ExtCodeSecretVar defines flag with the given name and usage string in the
+// given FlagSet to set a secret VMVar in the given VMVarMap. The VMVar sets
+// an extVar code literal in a jsonnet VM and its value is redacted everywhere
+// else (see NewSecret).
+//
+// The flag value on the command line is parsed as for ExtStrSecretVar.
+//
+// The flag can be repeated multiple times on the command line. Each instance
+// adds a new value to the given VMVarMap.
+func ExtCodeSecretVar(fs *flag.FlagSet, m VMVarMap, name, usage string) {
+	fs.Var(vmSecretVarMapValue{m, NewExtCode}, name, usage)
+}
+
+// TLAStrSecretVar defines flag with the given name and usage string in the
+// given FlagSet to set a secret VMVar in the given VMVarMap. The VMVar sets a
+// top-level arg string literal in a jsonnet VM and its value is redacted
+// everywhere else (see NewSecret).
+//
+// The flag value on the command line is parsed as for ExtStrSecretVar.
+//
+// The flag can be repeated multiple times on the command line. Each instance
+// adds a new value to the given VMVarMap.
+func TLAStrSecretVar(fs *flag.FlagSet, m VMVarMap, name, usage string) {
+	fs.Var(vmSecretVarMapValue{m, NewTLAStr}, name, usage)
+}
+
+// TLACodeSecretVar defines flag with the given name and usage string in the
+// given FlagSet to set a secret VMVar in the given VMVarMap. The VMVar sets a
+// top-level arg code literal in a jsonnet VM and its value is redacted
+// everywhere else (see NewSecret).
+//
+// The flag value on the command line is parsed as for ExtStrSecretVar.
+//
+// The flag can be repeated multiple times on the command line. Each instance
+// adds a new value to the given VMVarMap.
+func TLACodeSecretVar(fs *flag.FlagSet, m VMVarMap, name, usage string) {
+	fs.Var(vmSecretVarMapValue{m, NewTLACode}, name, usage)
+}
+
+// ExtObjVar defines flag with the given name and usage string in the given
+// FlagSet to set fields of VMVars in the given VMVarMap. The VMVars set
+// extVar objects as code literals in a jsonnet VM.
+//
+// The flag value on the command line is parsed as "var.field=value", where
+// the path to the field may have any number of dotted elements. Scalar values
+// are auto-typed and a quoted value is a string. See VMVarMap.SetVarPath.
+//
+// The flag can be repeated multiple times on the command line. Each instance
+// sets a field of the object, merging with the fields set before.
+func ExtObjVar(fs *flag.FlagSet, m VMVarMap, name, usage string) {
+	fs.Var(vmVarPathValue{m, NewExtCode}, name, usage)
+}
+
+// ExtStrCmdVar defines flag with the given name and usage string in the given
+// FlagSet to set a VMVar in the ExtVars of the given Config. The VMVar sets an
+// extVar string literal in a jsonnet VM from the output of a command.
+//
+// The flag value on the command line is parsed as "key=command". The command
+// is run when the flag is parsed, as described for Config.SetExtStrCmd. It is
+// an error if the command fails or if c.NoCommands is true when the flag is
+// parsed.
+//
+// The flag can be repeated multiple times on the command line. Each instance
+// adds a new value to the ExtVars of c.
+func ExtStrCmdVar(fs *flag.FlagSet, c *Config, name, usage string) {
+	fs.Var(cmdVarValue{c.ExtVars, c.SetExtStrCmd}, name, usage)
+}
+
+// TLAStrCmdVar defines flag with the given name and usage string in the given
+// FlagSet to set a VMVar in the TLAVars of the given Config. The VMVar sets a
+// top-level arg string literal in a jsonnet VM from the output of a command.
+//
+// The flag value on the command line is parsed as for ExtStrCmdVar.
+//
+// The flag can be repeated multiple times on the command line. Each instance
+// adds a new value to the TLAVars of c.
+func TLAStrCmdVar(fs *flag.FlagSet, c *Config, name, usage string) {
+	fs.Var(cmdVarValue{c.TLAVars, c.SetTLAStrCmd}, name, usage)
+}
+
 // vmVarMapValue wraps a VMVarMap with the additional information it needs to
 // parse the various types of VMVars, using a makevar function to construct the
 // values in the map.
@@ -222,3 +568,121 @@ func (mv vmVarMapValue) String() string {
 func (mv vmVarMapValue) Get() interface{} {
 	return mv.m
 }
+
+// vmSecretVarMapValue wraps a VMVarMap to set secret vars in it, using makevar
+// to construct the VMVars that are made secret.
+type vmSecretVarMapValue struct {
+	m       VMVarMap
+	makevar func(string) VMVar
+}
+
+// Set sets the flag var value from v. It implements the flag.Value interface.
+func (mv vmSecretVarMapValue) Set(v string) error {
+	return mv.m.SetSecretVar(v, mv.makevar)
+}
+
+// String returns a string representation of the value, with secrets
+// redacted. It implements the flag.Value interface.
+func (mv vmSecretVarMapValue) String() string {
+	return fmt.Sprint(mv.m)
+}
+
+// Get returns the underlying VMVarMap. It implements the flag.Getter interface.
+func (mv vmSecretVarMapValue) Get() interface{} {
+	return mv.m
+}
+
+// vmVarPathValue wraps a VMVarMap to set fields of object vars in it, using
+// makevar to construct the values in the map.
+type vmVarPathValue struct {
+	m       VMVarMap
+	makevar func(string) VMVar
+}
+
+// Set sets the flag var value from v. It implements the flag.Value interface.
+func (pv vmVarPathValue) Set(v string) error {
+	return pv.m.SetVarPath(v, pv.makevar)
+}
+
+// String returns a string representation of the value. It implements the
+// flag.Value interface.
+func (pv vmVarPathValue) String() string {
+	return fmt.Sprint(pv.m)
+}
+
+// Get returns the underlying VMVarMap. It implements the flag.Getter interface.
+func (pv vmVarPathValue) Get() interface{} {
+	return pv.m
+}
+
+// cmdVarValue wraps a VMVarMap of a Config to set vars in it from the output
+// of commands with set, Config.SetExtStrCmd or Config.SetTLAStrCmd.
+type cmdVarValue struct {
+	m   VMVarMap
+	set func(string) error
+}
+
+// Set sets the flag var value from v. It implements the flag.Value interface.
+func (cv cmdVarValue) Set(v string) error {
+	return cv.set(v)
+}
+
+// String returns a string representation of the value. It implements the
+// flag.Value interface.
+func (cv cmdVarValue) String() string {
+	return fmt.Sprint(cv.m)
+}
+
+// Get returns the underlying VMVarMap. It implements the flag.Getter interface.
+func (cv cmdVarValue) Get() interface{} {
+	return cv.m
+}
+
+// vmVarsFileValue wraps a VMVarMap to set vars in it from the fields of a
+// file, using makeStr and makeCode to construct the values in the map.
+type vmVarsFileValue struct {
+	m        VMVarMap
+	makeStr  func(string) VMVar
+	makeCode func(string) VMVar
+}
+
+// Set sets vars from the fields of the file named v. It implements the
+// flag.Value interface.
+func (fv vmVarsFileValue) Set(v string) error {
+	return fv.m.SetVarsFromFile(v, fv.makeStr, fv.makeCode)
+}
+
+// String returns a string representation of the value. It implements the
+// flag.Value interface.
+func (fv vmVarsFileValue) String() string {
+	return fmt.Sprint(fv.m)
+}
+
+// Get returns the underlying VMVarMap. It implements the flag.Getter interface.
+func (fv vmVarsFileValue) Get() interface{} {
+	return fv.m
+}
+
+// vmVarEnvValue wraps a VMVarMap to set vars in it from environment variables
+// with a prefix, using makevar to construct the values in the map.
+type vmVarEnvValue struct {
+	m       VMVarMap
+	makevar func(string) VMVar
+}
+
+// Set sets vars from the environment variables starting with v. It
+// implements the flag.Value interface.
+func (ev vmVarEnvValue) Set(v string) error {
+	return ev.m.SetVarsFromEnv(v, ev.makevar)
+}
+
+// String returns a string representation of the value. It implements the
+// flag.Value interface.
+func (ev vmVarEnvValue) String() string {
+	return fmt.Sprint(ev.m)
+}
+
+// Get returns the underlying VMVarMap. It implements the flag.Getter interface.
+func (ev vmVarEnvValue) Get() interface{} {
+	return ev.m
+}
@@ -13,7 +13,10 @@ type suite struct{}
 func (s *suite) Parse(t *testing.T, args []string) (*jsonnext.Config, error) {
 	fs := new(flag.FlagSet)
 	cfg := jsonnext.ConfigFlags(fs)
-	return cfg, fs.Parse(args[1:])
+	if err := fs.Parse(args[1:]); err != nil {
+		return nil, err
+	}
+	return cfg, cfg.LoadEnv(jsonnext.EnvPrefix)
 }
 
 func TestConformance(t *testing.T) {
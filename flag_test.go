@@ -32,3 +32,85 @@ func TestVMVarMapGet(t *testing.T) {
 	require.NotNil(t, f)
 	require.Equal(t, m, f.Value.(flag.Getter).Get())
 }
+
+func flagNames(fs *flag.FlagSet) []string {
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) { names = append(names, f.Name) })
+	return names
+}
+
+func TestConfigFlagsOptions(t *testing.T) {
+	extVarFlags := []string{
+		"ext-code", "ext-code-file", "ext-code-secret", "ext-obj", "ext-str",
+		"ext-str-cmd", "ext-str-env-prefix", "ext-str-file", "ext-str-secret", "ext-vars-file",
+	}
+	tests := map[string]struct {
+		opts     []FlagOption
+		expected []string
+	}{
+		"import path": {
+			opts:     []FlagOption{WithFlagGroups(FlagsImportPath)},
+			expected: []string{"J", "jpath"},
+		},
+		"import path no short": {
+			opts:     []FlagOption{WithFlagGroups(FlagsImportPath), WithoutShortFlags()},
+			expected: []string{"jpath"},
+		},
+		"ext vars": {
+			opts:     []FlagOption{WithFlagGroups(FlagsExtVars)},
+			expected: append([]string{"O", "V"}, extVarFlags...),
+		},
+		"ext vars no short": {
+			opts:     []FlagOption{WithoutShortFlags(), WithFlagGroups(FlagsExtVars)},
+			expected: extVarFlags,
+		},
+		"prefix": {
+			opts:     []FlagOption{WithFlagPrefix("jsonnet."), WithFlagGroups(FlagsImportPath | FlagsVM)},
+			expected: []string{"jsonnet.J", "jsonnet.jpath", "jsonnet.max-import-bytes", "jsonnet.max-imports", "jsonnet.max-output", "jsonnet.max-stack", "jsonnet.max-trace", "jsonnet.timeout"},
+		},
+		"prefix no short": {
+			opts:     []FlagOption{WithFlagPrefix("j-"), WithoutShortFlags(), WithFlagGroups(FlagsTLAVars)},
+			expected: []string{"j-tla-code", "j-tla-code-file", "j-tla-code-secret", "j-tla-str", "j-tla-str-cmd", "j-tla-str-file", "j-tla-str-secret", "j-tla-vars-file"},
+		},
+		"no groups": {
+			opts: []FlagOption{WithFlagGroups(0)},
+		},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			fs := flag.NewFlagSet(t.Name(), flag.ContinueOnError)
+			ConfigFlags(fs, tc.opts...)
+			require.Equal(t, tc.expected, flagNames(fs))
+		})
+	}
+
+	fs := flag.NewFlagSet(t.Name(), flag.ContinueOnError)
+	ConfigFlags(fs)
+	require.Len(t, flagNames(fs), 29)
+}
+
+func TestConfigFlagsPrefixParse(t *testing.T) {
+	fs := flag.NewFlagSet(t.Name(), flag.ContinueOnError)
+	fs.String("J", "", "an existing flag")
+	c := ConfigFlags(fs, WithFlagPrefix("jsonnet."), WithoutShortFlags())
+	err := fs.Parse([]string{"-J", "x", "-jsonnet.jpath", "lib", "-jsonnet.ext-str", "a=b", "-jsonnet.max-stack", "7"})
+	require.NoError(t, err)
+	expected := NewConfig()
+	expected.ImportPath = []string{"lib"}
+	expected.ExtVars["a"] = NewExtStr("b")
+	expected.MaxStack = 7
+	expected.MarkSet("max-stack")
+	require.Equal(t, expected, c)
+}
+
+func TestConfigFlagsUsage(t *testing.T) {
+	fs := flag.NewFlagSet(t.Name(), flag.ContinueOnError)
+	ConfigFlags(fs, WithFlagUsage("jpath", "Add a `directory` to the jsonnet path"), WithFlagUsage("timeout", "Render timeout"))
+	require.Equal(t, "Add a `directory` to the jsonnet path", fs.Lookup("jpath").Usage)
+	require.Equal(t, "Add a `directory` to the jsonnet path", fs.Lookup("J").Usage)
+	require.Equal(t, "Render timeout", fs.Lookup("timeout").Usage)
+	name, _ := flag.UnquoteUsage(fs.Lookup("J"))
+	require.Equal(t, "directory", name)
+	require.Equal(t, "Maximum number of files imported (no limit if 0)", fs.Lookup("max-imports").Usage)
+}
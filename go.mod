@@ -4,11 +4,15 @@ go 1.13
 
 require (
 	foxygo.at/s v0.0.42
-	github.com/alecthomas/kong v0.2.15
+	github.com/alecthomas/kong v0.5.0
 	github.com/google/go-jsonnet v0.17.0
 	github.com/kr/text v0.2.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
+	github.com/spf13/pflag v1.0.5
 	github.com/stretchr/testify v1.7.0
+	github.com/urfave/cli/v2 v2.3.0
+	golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871
+	golang.org/x/sync v0.0.0-20220907140024-f12130a52804
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
-	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
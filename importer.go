@@ -1,6 +1,8 @@
 package jsonnext
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -9,11 +11,16 @@ import (
 	"path"
 	"path/filepath"
 
+	"foxygo.at/s/errs"
 	jsonnet "github.com/google/go-jsonnet"
 )
 
 const stdin = ""
 
+// ErrNotFound is wrapped by the error returned from Importer.Import when the
+// imported path is not found.
+var ErrNotFound = errors.New("not found")
+
 var noContent = jsonnet.Contents{} //nolint:gochecknoglobals
 
 // A URLFetcher retrieves a URL returning a http.Response or an error. It
@@ -24,6 +31,12 @@ type URLFetcher interface {
 	Get(url string) (*http.Response, error)
 }
 
+// requestDoer is implemented by a URLFetcher, such as http.Client, that can
+// make requests with a context.
+type requestDoer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
 // Importer implements the jsonnet.Importer interface, allowing jsonnet code to
 // be imported via https in addition to local files. Filenames starting with a
 // double-slash (`//`) are fetched via HTTPS using the Fetcher of the Importer.
@@ -38,6 +51,16 @@ type URLFetcher interface {
 // not possible for the same import statement from different files to result in
 // different content. If an Importer is shared across multiple jsonnet.VM
 // instances, the the cache will be shared too. There is no cache expiry logic.
+//
+// Local paths are cached by their canonical form - an absolute path with
+// symlinks evaluated - so different paths to the same file, such as
+// "lib/x.libsonnet", "lib/../lib/x.libsonnet" or a symlink to it, share one
+// cache entry and return the same jsonnet.Contents, so the file is read once.
+// The canonical form is only used as the cache key. The location returned
+// from Import is the path as it was searched for, so that error messages,
+// stack traces and std.thisFile refer to the path the user wrote, and imports
+// relative to a file found through a symlink are relative to the directory of
+// the symlink.
 type Importer struct {
 	// SearchPath is an ordered slice of paths (network or local filesystem)
 	// that is prepended to the imported filename if the filename is not
@@ -48,7 +71,61 @@ type Importer struct {
 	// &http.Client{}
 	Fetcher URLFetcher
 
-	cache map[string]jsonnet.Contents
+	// DiscoverDirs is a list of directory names, such as "vendor" or "lib",
+	// to search for relative imports from local files. They are looked for
+	// in the directory of the importing file and each of its parents, up to
+	// the project root. Discovered directories closer to the importing file
+	// are searched first. Discovery is disabled if DiscoverDirs is empty.
+	DiscoverDirs []string
+
+	// RootMarkers are the names of files or directories, such as ".git" or
+	// "jsonnetfile.json", that mark the root directory of a project.
+	// Discovery of DiscoverDirs stops at the first directory containing one
+	// of them. If there are no RootMarkers, discovery continues up to the
+	// filesystem root.
+	RootMarkers []string
+
+	// DiscoverFirst searches discovered directories before SearchPath
+	// when true, and after it when false.
+	DiscoverFirst bool
+
+	// Bundle, if not nil, resolves relative imports of jsonnet-bundler
+	// dependencies, such as "github.com/org/repo/lib.libsonnet", directly
+	// to the netpaths of their pinned versions. It is tried after the
+	// directory of the importing file and before the SearchPath.
+	Bundle *Bundle
+
+	// Signatures, if not nil, verifies the detached signature of each
+	// file fetched from a netpath. Files that are unsigned or are not
+	// signed by a trusted key fail to import with a *SignatureError.
+	Signatures *SignatureVerifier
+
+	// Context, if not nil, bounds the lifetime of imports. Once it is
+	// done, imports fail with its error and in-flight fetches of
+	// netpaths are cancelled if the Fetcher implements
+	// Do(*http.Request) (*http.Response, error), as http.Client does.
+	Context context.Context
+
+	// MaxImports limits the number of files read, and MaxImportBytes
+	// their total size in bytes. Results from the cache are not counted.
+	// The files are counted over the lifetime of the Importer, or over
+	// each evaluation when used by an Evaluator, which clears the cache
+	// before each evaluation. Once a limit is exceeded, imports fail with
+	// a *LimitError. There is no limit if zero.
+	MaxImports     int
+	MaxImportBytes int
+
+	cache       map[string]jsonnet.Contents
+	imports     int
+	importBytes int
+	limitErr    error
+}
+
+// ClearCache removes all results from the import cache of the Importer. It
+// must not be called while the Importer is used by a jsonnet.VM, as an import
+// must always return the same result for the lifetime of a VM.
+func (i *Importer) ClearCache() {
+	i.cache = nil
 }
 
 // AppendSearchFromEnv appends a list of search paths specified in the given
@@ -66,9 +143,9 @@ func (i *Importer) AppendSearchFromEnv(envvar string) {
 
 // Import loads imp from a file or a network location. If imp is a relative
 // path, search for it relative to the directory of source and the search path
-// elements. If the import found, return its contents and the absolute location
-// where it was found. If it was not found, or there was an error reading
-// the content, return the error.
+// elements. If the import found, return its contents and the location where
+// it was found. If it was not found, or there was an error reading the
+// content, return the error.
 //
 // Import will cache the result and return it the next time that path is
 // requested.
@@ -76,6 +153,9 @@ func (i *Importer) AppendSearchFromEnv(envvar string) {
 // This method is defined in the jsonnet.Importer interface:
 //   https://godoc.org/github.com/google/go-jsonnet#Importer
 func (i *Importer) Import(source, imp string) (jsonnet.Contents, string, error) {
+	if i.Context != nil && i.Context.Err() != nil {
+		return noContent, "", i.Context.Err()
+	}
 	imp = mapStdin(imp)
 	dir := path.Dir(source)
 	if dir = preserveNetRoot(source, dir); dir == "//" {
@@ -86,9 +166,8 @@ func (i *Importer) Import(source, imp string) (jsonnet.Contents, string, error)
 	content, location, err := i.search(imp, dir)
 
 	if err == nil && content == noContent {
-		err = fmt.Errorf("could not read %#v: not found", imp)
+		err = errs.Errorf("could not read %#v: %v", imp, ErrNotFound)
 	}
-
 	return content, location, err
 }
 
@@ -98,9 +177,9 @@ func (i *Importer) search(imp, dir string) (jsonnet.Contents, string, error) {
 		return content, imp, err
 	}
 
-	// try to import imp relative to source first, then the search path
-	for _, p := range append([]string{dir}, i.SearchPath...) {
-		location := preserveNetRoot(p, path.Join(p, imp))
+	// try to import imp relative to source first, then the bundle, search
+	// path and discovered directories
+	for _, location := range i.locations(imp, dir) {
 		content, err := i.readViaCache(location)
 		// content found, or an error. Stop searching - we're done
 		// TODO(camh): Keep searching on hard errors. If a fetch results
@@ -119,12 +198,86 @@ func (i *Importer) search(imp, dir string) (jsonnet.Contents, string, error) {
 	return noContent, "", nil // not found
 }
 
+// locations returns the locations to try in order for a relative import imp
+// from a source in dir. The location relative to dir is first, followed by the
+// location of imp in a jsonnet-bundler dependency if there is a Bundle, then
+// imp relative to the other search directories.
+func (i *Importer) locations(imp, dir string) []string {
+	dirs := i.searchDirs(dir)
+	locations := make([]string, 0, len(dirs)+1)
+	for _, p := range dirs {
+		locations = append(locations, preserveNetRoot(p, path.Join(p, imp)))
+	}
+	if i.Bundle != nil {
+		if location, ok := i.Bundle.Resolve(imp); ok {
+			locations = append(locations[:1], append([]string{location}, locations[1:]...)...)
+		}
+	}
+	return locations
+}
+
+// searchDirs returns the directories to search for a relative import from a
+// source in dir: dir itself and the SearchPath, with the directories
+// discovered from dir before or after the SearchPath.
+func (i *Importer) searchDirs(dir string) []string {
+	discovered := i.discover(dir)
+	dirs := make([]string, 0, 1+len(discovered)+len(i.SearchPath))
+	dirs = append(dirs, dir)
+	if i.DiscoverFirst {
+		dirs = append(dirs, discovered...)
+	}
+	dirs = append(dirs, i.SearchPath...)
+	if !i.DiscoverFirst {
+		dirs = append(dirs, discovered...)
+	}
+	return dirs
+}
+
+// discover returns the DiscoverDirs that exist in the local directory dir and
+// its parents, up to and including the first directory that contains one of
+// the RootMarkers.
+func (i *Importer) discover(dir string) []string {
+	if len(i.DiscoverDirs) == 0 || isNetpath(dir) {
+		return nil
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil
+	}
+
+	var found []string
+	for {
+		for _, name := range i.DiscoverDirs {
+			p := filepath.Join(abs, name)
+			if fi, err := os.Stat(p); err == nil && fi.IsDir() {
+				found = append(found, filepath.ToSlash(p))
+			}
+		}
+		parent := filepath.Dir(abs)
+		if i.isRoot(abs) || parent == abs {
+			return found
+		}
+		abs = parent
+	}
+}
+
+// isRoot returns true if dir contains any of the RootMarkers.
+func (i *Importer) isRoot(dir string) bool {
+	for _, marker := range i.RootMarkers {
+		if _, err := os.Stat(filepath.Join(dir, marker)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
 func (i *Importer) readViaCache(imp string) (jsonnet.Contents, error) {
 	if i.cache == nil {
 		i.cache = make(map[string]jsonnet.Contents)
 	}
 
-	if content, ok := i.cache[imp]; ok {
+	key := cacheKey(imp)
+	if content, ok := i.cache[key]; ok {
 		return content, nil
 	}
 
@@ -133,26 +286,124 @@ func (i *Importer) readViaCache(imp string) (jsonnet.Contents, error) {
 		return noContent, err
 	}
 
-	i.cache[imp] = content
+	i.cache[key] = content
 	return content, nil
 }
 
+// cacheKey returns the key used to cache the contents of imp. Local paths are
+// canonicalised to an absolute path with all symlinks evaluated. If the path
+// does not exist, symlinks cannot be evaluated so just the absolute path is
+// used. Netpaths and stdin are returned unchanged.
+func cacheKey(imp string) string {
+	if imp == stdin || isNetpath(imp) {
+		return imp
+	}
+	abs, err := filepath.Abs(imp)
+	if err != nil {
+		return imp
+	}
+	if p, err := filepath.EvalSymlinks(abs); err == nil {
+		return p
+	}
+	return abs
+}
+
 func (i *Importer) fetch(imp string) (jsonnet.Contents, error) {
 	if imp == stdin {
 		imp = "/dev/stdin"
 	}
+	b, err := i.read(imp, i.remainingBytes())
+	if b == nil || err != nil {
+		return noContent, err
+	}
+	if err := i.count(imp, len(b)); err != nil {
+		return noContent, err
+	}
+
+	if isNetpath(imp) && i.Signatures != nil {
+		if err := i.verify(imp, b); err != nil {
+			return noContent, err
+		}
+	}
+
+	return jsonnet.MakeContents(string(b)), nil
+}
+
+// read returns the contents of imp, or nil if it does not exist. If max is not
+// negative, no more than max+1 bytes are read so that a file larger than max
+// can be detected without reading it all.
+func (i *Importer) read(imp string, max int) ([]byte, error) {
 	r, err := i.open(imp)
 	if r == nil || err != nil {
-		return noContent, err
+		return nil, err
 	}
 
 	defer r.Close() //nolint:errcheck
-	b, err := ioutil.ReadAll(r)
+	var rd io.Reader = r
+	if max >= 0 {
+		rd = io.LimitReader(r, int64(max)+1)
+	}
+	b, err := ioutil.ReadAll(rd)
 	if err != nil {
-		return noContent, err
+		return nil, err
 	}
 
-	return jsonnet.MakeContents(string(b)), nil
+	return b, nil
+}
+
+// remainingBytes returns the number of bytes that can still be imported under
+// MaxImportBytes, or -1 if there is no limit.
+func (i *Importer) remainingBytes() int {
+	if i.MaxImportBytes <= 0 {
+		return -1
+	}
+	if n := i.MaxImportBytes - i.importBytes; n > 0 {
+		return n
+	}
+	return 0
+}
+
+// count records the import of n bytes from imp, returning a *LimitError if
+// that exceeds MaxImports or MaxImportBytes.
+func (i *Importer) count(imp string, n int) error {
+	i.imports++
+	i.importBytes += n
+	switch {
+	case i.MaxImports > 0 && i.imports > i.MaxImports:
+		i.limitErr = &LimitError{Err: ErrImportLimit, Limit: i.MaxImports, Path: imp}
+	case i.MaxImportBytes > 0 && i.importBytes > i.MaxImportBytes:
+		i.limitErr = &LimitError{Err: ErrImportBytesLimit, Limit: i.MaxImportBytes, Path: imp}
+	default:
+		return nil
+	}
+	return i.limitErr
+}
+
+// resetUsage resets the counts of files and bytes imported.
+func (i *Importer) resetUsage() {
+	i.imports, i.importBytes, i.limitErr = 0, 0, nil
+}
+
+// verify checks the signature of the netpath imp with contents b, if
+// signatures are required for that netpath.
+func (i *Importer) verify(imp string, b []byte) error {
+	keys := i.Signatures.keys(imp)
+	if keys == nil {
+		return nil
+	}
+
+	sig, err := i.read(i.Signatures.sigPath(imp), -1)
+	if err != nil {
+		return err
+	}
+	if sig == nil {
+		return &SignatureError{Path: imp, Err: ErrUnsigned}
+	}
+	if err := i.Signatures.verify(keys, b, sig); err != nil {
+		return &SignatureError{Path: imp, Err: err}
+	}
+
+	return nil
 }
 
 func (i *Importer) open(imp string) (io.ReadCloser, error) {
@@ -164,7 +415,7 @@ func (i *Importer) open(imp string) (io.ReadCloser, error) {
 		return r, err
 	}
 
-	resp, err := i.fetcher().Get("https:" + imp)
+	resp, err := i.get("https:" + imp)
 	if err != nil {
 		return nil, err
 	}
@@ -180,6 +431,21 @@ func (i *Importer) open(imp string) (io.ReadCloser, error) {
 	return resp.Body, nil
 }
 
+// get fetches url with the Fetcher, using the Context of the Importer if it
+// is set and the Fetcher supports it.
+func (i *Importer) get(url string) (*http.Response, error) {
+	f := i.fetcher()
+	d, ok := f.(requestDoer)
+	if i.Context == nil || !ok {
+		return f.Get(url)
+	}
+	req, err := http.NewRequestWithContext(i.Context, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return d.Do(req)
+}
+
 func (i *Importer) fetcher() URLFetcher {
 	// TODO(camh): Consider whether this needs to be concurrency-safe
 	if i.Fetcher == nil {
@@ -1,12 +1,15 @@
 package jsonnext
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	jsonnet "github.com/google/go-jsonnet"
 	"github.com/stretchr/testify/require"
 )
 
@@ -79,6 +82,110 @@ func TestImportLocalRelativeSearch(t *testing.T) {
 	require.Equal(t, "testdata/importer/hello.txt", foundAt)
 }
 
+// Test that different paths to the same file share a cache entry, and return
+// the same contents, while still returning the location as it was searched.
+func TestImportLocalCanonical(t *testing.T) {
+	i := Importer{}
+	c1, foundAt, err := i.Import("", "testdata/importer/hello.txt")
+	require.NoError(t, err)
+	require.Equal(t, "testdata/importer/hello.txt", foundAt)
+
+	c2, foundAt, err := i.Import("", "testdata/../testdata/importer/hello.txt")
+	require.NoError(t, err)
+	require.Equal(t, "testdata/importer/hello.txt", foundAt)
+	require.True(t, c1 == c2, "contents should be the same cache entry")
+
+	c3, foundAt, err := i.Import("testdata/importer/mellow.txt", "symlink.txt")
+	require.NoError(t, err)
+	require.Equal(t, "testdata/importer/symlink.txt", foundAt)
+	require.True(t, c1 == c3, "contents should be the same cache entry")
+	require.Equal(t, 1, len(i.cache))
+
+	abs, err := filepath.Abs("testdata/importer/hello.txt")
+	require.NoError(t, err)
+	_, foundAt, err = i.Import("", abs)
+	require.NoError(t, err)
+	require.Equal(t, abs, foundAt)
+
+	_, _, err = i.Import("", "testdata/../testdata/importer/notfound.txt")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `"testdata/../testdata/importer/notfound.txt"`)
+}
+
+// Test that jsonnet sees a file imported through a symlink at the path of the
+// symlink, as returned from Import, while it is read only once.
+func TestImportLocalCanonicalVM(t *testing.T) {
+	i := &Importer{}
+	vm := jsonnet.MakeVM()
+	vm.Importer(i)
+	code := `[import "testdata/importer/this.libsonnet", import "testdata/importer/thislink.libsonnet"]`
+	out, err := vm.EvaluateAnonymousSnippet("<snippet>", code)
+	require.NoError(t, err)
+	require.JSONEq(t, `["testdata/importer/this.libsonnet", "testdata/importer/thislink.libsonnet"]`, out)
+	require.Equal(t, 1, len(i.cache))
+}
+
+func TestImportDiscover(t *testing.T) {
+	abs, err := filepath.Abs("testdata/discover")
+	require.NoError(t, err)
+	const source = "testdata/discover/project/src/app/main.jsonnet"
+	tests := map[string]struct {
+		importer Importer
+		imp      string
+		expected string // relative to testdata/discover, or "" for not found
+	}{
+		"disabled": {
+			importer: Importer{},
+			imp:      "dep.libsonnet",
+		},
+		"nearest": {
+			importer: Importer{DiscoverDirs: []string{"vendor", "lib"}},
+			imp:      "dep.libsonnet",
+			expected: "project/src/vendor/dep.libsonnet",
+		},
+		"parent": {
+			importer: Importer{DiscoverDirs: []string{"vendor", "lib"}},
+			imp:      "util.libsonnet",
+			expected: "project/lib/util.libsonnet",
+		},
+		"root-marker": {
+			importer: Importer{DiscoverDirs: []string{"vendor"}, RootMarkers: []string{".root"}},
+			imp:      "outside.libsonnet",
+		},
+		"no-root-marker": {
+			importer: Importer{DiscoverDirs: []string{"vendor"}},
+			imp:      "outside.libsonnet",
+			expected: "vendor/outside.libsonnet",
+		},
+		"after-search-path": {
+			importer: Importer{DiscoverDirs: []string{"lib"}, SearchPath: []string{"testdata/discover/searchpath"}},
+			imp:      "util.libsonnet",
+			expected: "searchpath/util.libsonnet",
+		},
+		"before-search-path": {
+			importer: Importer{DiscoverDirs: []string{"lib"}, SearchPath: []string{"testdata/discover/searchpath"}, DiscoverFirst: true},
+			imp:      "util.libsonnet",
+			expected: "project/lib/util.libsonnet",
+		},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			_, foundAt, err := tc.importer.Import(source, tc.imp)
+			if tc.expected == "" {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			if !filepath.IsAbs(foundAt) {
+				foundAt, err = filepath.Abs(foundAt)
+				require.NoError(t, err)
+			}
+			require.Equal(t, filepath.Join(abs, tc.expected), foundAt)
+		})
+	}
+}
+
 func TestImportNetpath(t *testing.T) {
 	s := httptest.NewTLSServer(http.FileServer(http.Dir("testdata")))
 	defer s.Close()
@@ -171,6 +278,31 @@ func TestImportFromCache(t *testing.T) {
 	require.Equal(t, 1, len(rr.requests)) // still 1
 }
 
+func TestImportLimits(t *testing.T) {
+	i := Importer{MaxImports: 1}
+	_, _, err := i.Import("", "testdata/importer/hello.txt")
+	require.NoError(t, err)
+	_, _, err = i.Import("", "testdata/importer/mellow.txt")
+	var lerr *LimitError
+	require.True(t, errors.As(err, &lerr), "%v", err)
+	require.True(t, errors.Is(err, ErrImportLimit))
+	require.Equal(t, 1, lerr.Limit)
+	require.Equal(t, "testdata/importer/mellow.txt", lerr.Path)
+	_, _, err = i.Import("", "testdata/importer/hello.txt")
+	require.NoError(t, err) // cached
+
+	i = Importer{MaxImportBytes: 20}
+	_, _, err = i.Import("", "testdata/importer/hello.txt") // 12 bytes
+	require.NoError(t, err)
+	_, _, err = i.Import("", "testdata/importer/mellow.txt") // 13 bytes
+	require.True(t, errors.Is(err, ErrImportBytesLimit), "%v", err)
+	require.Equal(t, `imported bytes limit exceeded: more than 20 bytes importing "testdata/importer/mellow.txt"`, err.Error())
+
+	i.resetUsage()
+	_, _, err = i.Import("", "testdata/importer/mellow.txt")
+	require.NoError(t, err)
+}
+
 // Test that an import of an absolute path does not go through the search
 // path, by importing non-existent file.
 func TestImportAbsolute(t *testing.T) {
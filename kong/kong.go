@@ -11,22 +11,61 @@
 // handling as the default kong features do not support the style of CLI
 // parsing that is compatible with the standard jsonnet CLI.
 //
+// The scalar flags, such as --max-stack, are set from the environment
+// variables named in their env tags, such as JNX_MAX_STACK, by kong itself.
+// After parsing, the import path, external variables and top-level arguments
+// are filled from the JNX_ environment variables described for
+// jsonnext.Config.LoadEnv, such as JNX_JPATH and JNX_EXT_STR_<var>.
+//
+// To keep the flags apart from those of a larger CLI, embed Config in a named
+// field with kong's embed tag, adding a prefix for the flag names, an
+// envprefix for the environment variables and a group to list the flags under
+// their own heading in the help:
+//
+//   type cli struct {
+//       Jsonnet jnxkong.Config `embed:"" prefix:"jsonnet-" envprefix:"APP_" group:"Jsonnet"`
+//       ...
+//   }
+//
+// This defines --jsonnet-jpath, and the Config is filled from APP_JNX_JPATH,
+// APP_JNX_MAX_STACK and so on instead of the unprefixed variables. Short
+// flags such as -J are not prefixed.
+//
+// kong only calls hooks on the top-level struct, so an embedding struct must
+// call the AfterApply hook of the embedded Config by hand from its own
+// AfterApply hook, passing on its kong.Context:
+//
+//   func (c *cli) AfterApply(ctx *kong.Context) error {
+//       return c.Jsonnet.AfterApply(ctx)
+//   }
+//
+// Without it, the environment is not loaded and the scalar fields set on the
+// command line are not marked as set.
+//
 // This functionality is split into a separate sub-package so users of jsonnext
 // do not need to depend on kong if the do not use it.
 package kong
 
 import (
+	"os"
+	"strings"
+
 	"foxygo.at/jsonnext"
 	"github.com/alecthomas/kong"
 )
 
-// Config embeds jsonnext.Config to add kong command line parsing for external
-// variables and top-level arguments. The flags for these options are parsed
-// into the VMVarMaps in the embedded jsonnext.Config field.
+// Config embeds jsonnext.Config to add kong command line parsing for the
+// import path, external variables and top-level arguments. The flags for these
+// options are parsed into the ImportPath and VMVarMaps in the embedded
+// jsonnext.Config field.
 //
 // Since Config embeds jsonnext.Config, the method set of jsonnext.Config is
 // also present on Config.
 type Config struct {
+	// importPathFlag is embedded before jsonnext.Config so that --jpath is
+	// the first of the flags, as it is for ConfigFlags.
+	importPathFlag
+
 	*jsonnext.Config
 
 	// vmVarMaps is embedded and unexported, which prevents users of this
@@ -35,6 +74,10 @@ type Config struct {
 	vmVarMaps
 }
 
+type importPathFlag struct {
+	JPath importPath `name:"jpath" short:"J" placeholder:"dir" env:"JNX_JPATH" help:"Add a library search dir"`
+}
+
 type vmVarMaps struct {
 	ExtStr      vmVarMap `placeholder:"var[=str]" help:"Set extVar string (str from env if omitted)" short:"V"`
 	ExtStrFile  vmVarMap `placeholder:"var[=filename]" help:"Set extVar string from a file (filename from env if omitted)"`
@@ -44,12 +87,29 @@ type vmVarMaps struct {
 	TLAStrFile  vmVarMap `placeholder:"var[=filename]" help:"Set top-level arg string from a file (filename from env if omitted)"`
 	TLACode     vmVarMap `placeholder:"var[=code]" help:"Set top-level arg code (code from env if omitted)"`
 	TLACodeFile vmVarMap `placeholder:"var[=filename]" help:"Set top-level arg code from a file (filename from env if omitted)"`
+
+	ExtVarsFile     vmVarsFile     `placeholder:"filename" help:"Set extVars from the fields of a JSON or YAML file"`
+	TLAVarsFile     vmVarsFile     `placeholder:"filename" help:"Set top-level args from the fields of a JSON or YAML file"`
+	ExtStrEnvPrefix vmVarEnvPrefix `placeholder:"prefix" help:"Set extVar strings from env vars starting with prefix, with it removed"`
+
+	ExtStrSecret  vmSecretVarMap `placeholder:"var[=file]" help:"Set secret extVar string from a file or fd:N (from env if omitted)"`
+	ExtCodeSecret vmSecretVarMap `placeholder:"var[=file]" help:"Set secret extVar code from a file or fd:N (from env if omitted)"`
+	TLAStrSecret  vmSecretVarMap `placeholder:"var[=file]" help:"Set secret top-level arg string from a file or fd:N (from env if omitted)"`
+	TLACodeSecret vmSecretVarMap `placeholder:"var[=file]" help:"Set secret top-level arg code from a file or fd:N (from env if omitted)"`
+
+	ExtStrCmd vmCmdVarMap `placeholder:"var=command" help:"Set extVar string from the output of a command"`
+	TLAStrCmd vmCmdVarMap `placeholder:"var=command" help:"Set top-level arg string from the output of a command"`
+
+	ExtObj vmVarPath `placeholder:"var.field=value" help:"Set field of extVar object (quote value to force a string)" short:"O"`
 }
 
 // NewConfig returns an initialised Config struct embedding a jsonnext.Config.
 func NewConfig() *Config {
 	c := jsonnext.NewConfig()
 	return &Config{
+		importPathFlag: importPathFlag{
+			JPath: importPath{c: c},
+		},
 		Config: c,
 		vmVarMaps: vmVarMaps{
 			ExtStr:      vmVarMap{c.ExtVars, jsonnext.NewExtStr, "string"},
@@ -60,10 +120,110 @@ func NewConfig() *Config {
 			TLAStrFile:  vmVarMap{c.TLAVars, jsonnext.NewTLAStrFile, "filename"},
 			TLACode:     vmVarMap{c.TLAVars, jsonnext.NewTLACode, "code"},
 			TLACodeFile: vmVarMap{c.TLAVars, jsonnext.NewTLACodeFile, "filename"},
+
+			ExtVarsFile:     vmVarsFile{c.ExtVars, jsonnext.NewExtStr, jsonnext.NewExtCode},
+			TLAVarsFile:     vmVarsFile{c.TLAVars, jsonnext.NewTLAStr, jsonnext.NewTLACode},
+			ExtStrEnvPrefix: vmVarEnvPrefix{c.ExtVars, jsonnext.NewExtStr},
+
+			ExtStrSecret:  vmSecretVarMap{c.ExtVars, jsonnext.NewExtStr},
+			ExtCodeSecret: vmSecretVarMap{c.ExtVars, jsonnext.NewExtCode},
+			TLAStrSecret:  vmSecretVarMap{c.TLAVars, jsonnext.NewTLAStr},
+			TLACodeSecret: vmSecretVarMap{c.TLAVars, jsonnext.NewTLACode},
+
+			ExtStrCmd: vmCmdVarMap{c.SetExtStrCmd},
+			TLAStrCmd: vmCmdVarMap{c.SetTLAStrCmd},
+
+			ExtObj: vmVarPath{c.ExtVars, jsonnext.NewExtCode},
 		},
 	}
 }
 
+// AfterApply marks the fields of the Config set by flags on the command line
+// or by the environment variables of their env tags as set, as described for
+// jsonnext.Config.MarkSet, and then fills any fields not set from the
+// environment, as described for jsonnext.Config.LoadEnv. The prefix of the
+// environment variables is jsonnext.EnvPrefix, with the envprefix of the kong
+// tags embedding the Config, if any, in front of it. It is called by kong
+// after the command line has been parsed into ctx, but only when Config is
+// the top-level struct: a struct embedding Config must call it from its own
+// AfterApply hook.
+func (c *Config) AfterApply(ctx *kong.Context) error {
+	c.markSet(ctx)
+	c.JPath = importPath{c: c.Config}
+	return c.LoadEnv(c.envPrefix(ctx))
+}
+
+// markSet marks the scalar fields of c set by the flags on the command line
+// parsed into ctx, or by the environment variables of their env tags, as set.
+// kong sets a flag from its environment variable before parsing the command
+// line, so the value must not be replaced by LoadEnv or a project config file
+// even when it equals the default. The flags are found by their targets
+// rather than their names, which are prefixed when Config is embedded with a
+// prefix.
+func (c *Config) markSet(ctx *kong.Context) {
+	names := map[interface{}]string{
+		&c.MaxStack:       "max-stack",
+		&c.MaxTrace:       "max-trace",
+		&c.Timeout:        "timeout",
+		&c.MaxOutput:      "max-output",
+		&c.MaxImports:     "max-imports",
+		&c.MaxImportBytes: "max-import-bytes",
+	}
+	for _, f := range ctx.Flags() {
+		if f.Env == "" || os.Getenv(f.Env) == "" || !f.Target.CanAddr() {
+			continue
+		}
+		if name, ok := names[f.Target.Addr().Interface()]; ok {
+			c.MarkSet(name)
+		}
+	}
+	for _, p := range ctx.Path {
+		if p.Flag == nil || !p.Flag.Target.CanAddr() {
+			continue
+		}
+		if name, ok := names[p.Flag.Target.Addr().Interface()]; ok {
+			c.MarkSet(name)
+		}
+	}
+}
+
+// envPrefix returns the prefix of the environment variables for c parsed
+// into ctx. kong only applies an envprefix to the env tags of the flags, so
+// it is found from the env tag of the --jpath flag, which is the name of the
+// JPATH variable with the full prefix.
+func (c *Config) envPrefix(ctx *kong.Context) string {
+	for _, f := range ctx.Flags() {
+		if f.Target.CanAddr() && f.Target.Addr().Interface() == &c.JPath {
+			return strings.TrimSuffix(f.Env, "JPATH")
+		}
+	}
+	return jsonnext.EnvPrefix
+}
+
+type importPath struct {
+	c *jsonnext.Config
+}
+
+func (v *importPath) Decode(ctx *kong.DecodeContext) error {
+	// Initialise from ctx.Value.Target as for vmVarMap. When kong sets
+	// the flag from its environment variable, it does so after zeroing
+	// ctx.Value.Target and decodes into it, so v is still the zero value.
+	// The env tag is only there to carry the envprefix and show the
+	// variable in the help: the dirs from it are added by
+	// Config.AfterApply with the rest of the environment.
+	if v.c == nil {
+		*v = ctx.Value.Target.Interface().(importPath)
+	}
+	var dir string
+	if err := ctx.Scan.PopValueInto("dir", &dir); err != nil {
+		return err
+	}
+	if v.c != nil {
+		v.c.ImportPath = append(v.c.ImportPath, dir)
+	}
+	return nil
+}
+
 type vmVarMap struct {
 	m       jsonnext.VMVarMap
 	makevar func(string) jsonnext.VMVar
@@ -86,3 +246,88 @@ func (v *vmVarMap) Decode(ctx *kong.DecodeContext) error {
 	// Literals (i.e. not from files) can come from the environment
 	return v.m.SetVar(valstr, v.makevar)
 }
+
+type vmSecretVarMap struct {
+	m       jsonnext.VMVarMap
+	makevar func(string) jsonnext.VMVar
+}
+
+func (v *vmSecretVarMap) Decode(ctx *kong.DecodeContext) error {
+	// Initialise from ctx.Value.Target as for vmVarMap.
+	if v.m == nil {
+		*v = ctx.Value.Target.Interface().(vmSecretVarMap)
+	}
+	var valstr string
+	if err := ctx.Scan.PopValueInto("file", &valstr); err != nil {
+		return err
+	}
+	return v.m.SetSecretVar(valstr, v.makevar)
+}
+
+type vmVarPath struct {
+	m       jsonnext.VMVarMap
+	makevar func(string) jsonnext.VMVar
+}
+
+func (v *vmVarPath) Decode(ctx *kong.DecodeContext) error {
+	// Initialise from ctx.Value.Target as for vmVarMap.
+	if v.m == nil {
+		*v = ctx.Value.Target.Interface().(vmVarPath)
+	}
+	var valstr string
+	if err := ctx.Scan.PopValueInto("value", &valstr); err != nil {
+		return err
+	}
+	return v.m.SetVarPath(valstr, v.makevar)
+}
+
+type vmCmdVarMap struct {
+	set func(string) error
+}
+
+func (v *vmCmdVarMap) Decode(ctx *kong.DecodeContext) error {
+	// Initialise from ctx.Value.Target as for vmVarMap.
+	if v.set == nil {
+		*v = ctx.Value.Target.Interface().(vmCmdVarMap)
+	}
+	var valstr string
+	if err := ctx.Scan.PopValueInto("command", &valstr); err != nil {
+		return err
+	}
+	return v.set(valstr)
+}
+
+type vmVarsFile struct {
+	m        jsonnext.VMVarMap
+	makeStr  func(string) jsonnext.VMVar
+	makeCode func(string) jsonnext.VMVar
+}
+
+func (v *vmVarsFile) Decode(ctx *kong.DecodeContext) error {
+	// Initialise from ctx.Value.Target as for vmVarMap.
+	if v.m == nil {
+		*v = ctx.Value.Target.Interface().(vmVarsFile)
+	}
+	var filename string
+	if err := ctx.Scan.PopValueInto("filename", &filename); err != nil {
+		return err
+	}
+	return v.m.SetVarsFromFile(filename, v.makeStr, v.makeCode)
+}
+
+type vmVarEnvPrefix struct {
+	m       jsonnext.VMVarMap
+	makevar func(string) jsonnext.VMVar
+}
+
+func (v *vmVarEnvPrefix) Decode(ctx *kong.DecodeContext) error {
+	// Initialise from ctx.Value.Target as for vmVarMap.
+	if v.m == nil {
+		*v = ctx.Value.Target.Interface().(vmVarEnvPrefix)
+	}
+	var prefix string
+	if err := ctx.Scan.PopValueInto("prefix", &prefix); err != nil {
+		return err
+	}
+	return v.m.SetVarsFromEnv(prefix, v.makevar)
+}
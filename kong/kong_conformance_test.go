@@ -26,3 +26,31 @@ func TestConformance(t *testing.T) {
 	s := conformance.NewSuite(&suite{})
 	s.Run(t)
 }
+
+// embeddedCLI embeds the Config in a named field of a larger CLI, which
+// must call the AfterApply hook of the Config itself.
+type embeddedCLI struct {
+	Verbose bool
+	Jsonnet jnxkong.Config `embed:"" group:"Jsonnet"`
+}
+
+func (c *embeddedCLI) AfterApply(ctx *kong.Context) error {
+	return c.Jsonnet.AfterApply(ctx)
+}
+
+type embeddedSuite struct{}
+
+func (s *embeddedSuite) Parse(t *testing.T, args []string) (*jsonnext.Config, error) {
+	cli := &embeddedCLI{Jsonnet: *jnxkong.NewConfig()}
+	parser, err := kong.New(cli)
+	if err != nil {
+		return nil, err
+	}
+	_, err = parser.Parse(args[1:])
+	return cli.Jsonnet.Config, err
+}
+
+func TestConformanceEmbedded(t *testing.T) {
+	s := conformance.NewSuite(&embeddedSuite{})
+	s.Run(t)
+}
@@ -0,0 +1,123 @@
+package kong_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"foxygo.at/s/test"
+	"github.com/alecthomas/kong"
+	"github.com/stretchr/testify/require"
+
+	"foxygo.at/jsonnext"
+	jnxkong "foxygo.at/jsonnext/kong"
+)
+
+func TestNoCommands(t *testing.T) {
+	kcfg := jnxkong.NewConfig()
+	kcfg.NoCommands = true
+	parser, err := kong.New(kcfg)
+	require.NoError(t, err)
+	_, err = parser.Parse([]string{"--tla-str-cmd", "sha=git rev-parse HEAD"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), jsonnext.ErrCommandsDisabled.Error())
+	require.Empty(t, kcfg.TLAVars)
+}
+
+type prefixedCLI struct {
+	Verbose bool
+	Jsonnet jnxkong.Config `embed:"" prefix:"jsonnet-" envprefix:"APP_" group:"Jsonnet"`
+}
+
+func (c *prefixedCLI) AfterApply(ctx *kong.Context) error {
+	return c.Jsonnet.AfterApply(ctx)
+}
+
+func TestEmbedPrefix(t *testing.T) {
+	cli := &prefixedCLI{Jsonnet: *jnxkong.NewConfig()}
+	parser, err := kong.New(cli)
+	require.NoError(t, err)
+	args := []string{"--verbose", "--jsonnet-jpath", "a", "-J", "b", "--jsonnet-max-stack", "7", "--jsonnet-ext-str", "x=1"}
+	_, err = parser.Parse(args)
+	require.NoError(t, err)
+	expected := jsonnext.NewConfig()
+	expected.ImportPath = []string{"a", "b"}
+	expected.MaxStack = 7
+	expected.ExtVars = jsonnext.VMVarMap{"x": jsonnext.NewExtStr("1")}
+	expected.MarkSet("max-stack")
+	require.Equal(t, expected, cli.Jsonnet.Config)
+	require.True(t, cli.Verbose)
+
+	_, err = parser.Parse([]string{"--jpath", "a"})
+	require.Error(t, err)
+}
+
+func TestEmbedEnvPrefix(t *testing.T) {
+	test.Env.Set("APP_JNX_JPATH", strings.Join([]string{"a", "b"}, string(filepath.ListSeparator)))
+	test.Env.Set("APP_JNX_MAX_STACK", "7")
+	test.Env.Set("APP_JNX_TIMEOUT", "5s")
+	test.Env.Set("APP_JNX_EXT_STR_x", "1")
+	test.Env.Set("JNX_MAX_STACK", "8")
+	test.Env.Set("JNX_MAX_TRACE", "3")
+	test.Env.Set("JNX_EXT_STR_y", "2")
+	defer test.Env.Restore()
+
+	cli := &prefixedCLI{Jsonnet: *jnxkong.NewConfig()}
+	parser, err := kong.New(cli)
+	require.NoError(t, err)
+	_, err = parser.Parse(nil)
+	require.NoError(t, err)
+	// The unprefixed JNX_ variables are not used.
+	expected := jsonnext.NewConfig()
+	expected.ImportPath = []string{"a", "b"}
+	expected.MaxStack = 7
+	expected.Timeout = 5 * time.Second
+	expected.ExtVars = jsonnext.VMVarMap{"x": jsonnext.NewExtStr("1")}
+	expected.MarkSet("max-stack", "timeout")
+	require.Equal(t, expected, cli.Jsonnet.Config)
+
+	cli = &prefixedCLI{Jsonnet: *jnxkong.NewConfig()}
+	parser, err = kong.New(cli)
+	require.NoError(t, err)
+	_, err = parser.Parse([]string{"-J", "cli", "--jsonnet-max-stack", "9"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"cli", "a", "b"}, cli.Jsonnet.ImportPath)
+	require.Equal(t, 9, cli.Jsonnet.MaxStack)
+}
+
+func TestEnvTagDefault(t *testing.T) {
+	// A value from an env tag is marked as set even when it is the
+	// default, so a project config file does not replace it.
+	test.Env.Set("APP_JNX_MAX_STACK", "500")
+	defer test.Env.Restore()
+
+	cli := &prefixedCLI{Jsonnet: *jnxkong.NewConfig()}
+	parser, err := kong.New(cli)
+	require.NoError(t, err)
+	_, err = parser.Parse(nil)
+	require.NoError(t, err)
+	expected := jsonnext.NewConfig()
+	expected.MarkSet("max-stack")
+	require.Equal(t, expected, cli.Jsonnet.Config)
+}
+
+func TestEmbedHelp(t *testing.T) {
+	cli := &prefixedCLI{Jsonnet: *jnxkong.NewConfig()}
+	var out strings.Builder
+	exited := false
+	parser, err := kong.New(cli, kong.Writers(&out, &out), kong.Exit(func(int) { exited = true }))
+	require.NoError(t, err)
+	_, _ = parser.Parse([]string{"--help"})
+	require.True(t, exited)
+	help := out.String()
+	require.Contains(t, help, "\nJsonnet\n")
+	require.Contains(t, help, "-J, --jsonnet-jpath=dir")
+	require.Contains(t, help, "($APP_JNX_JPATH)")
+	require.Contains(t, help, "--jsonnet-max-stack=500")
+	require.Contains(t, help, "($APP_JNX_MAX_STACK)")
+	require.Contains(t, help, "($APP_JNX_TIMEOUT)")
+	require.Contains(t, help, "--jsonnet-ext-str=var[=str]")
+	// The flags of the embedding struct are listed before the group.
+	require.Less(t, strings.Index(help, "--verbose"), strings.Index(help, "\nJsonnet\n"))
+}
@@ -0,0 +1,60 @@
+package jsonnext
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Sentinel errors for the resource limits of a Config. A *LimitError wraps one
+// of them, so callers can use errors.Is to tell which limit was exceeded.
+var (
+	ErrOutputLimit      = errors.New("output size limit exceeded")
+	ErrImportLimit      = errors.New("import count limit exceeded")
+	ErrImportBytesLimit = errors.New("imported bytes limit exceeded")
+)
+
+// LimitError is returned when an evaluation exceeds one of the resource
+// limits MaxOutput, MaxImports or MaxImportBytes of a Config.
+type LimitError struct {
+	Err   error  // ErrOutputLimit, ErrImportLimit or ErrImportBytesLimit
+	Limit int    // the limit that was exceeded
+	Path  string // the import that exceeded an import limit
+}
+
+// Error returns the error message of e.
+func (e *LimitError) Error() string {
+	unit := " bytes"
+	if e.Err == ErrImportLimit { //nolint:errorlint,goerr113
+		unit = " imports"
+	}
+	msg := e.Err.Error() + ": more than " + strconv.Itoa(e.Limit) + unit
+	if e.Path != "" {
+		msg += " importing " + strconv.Quote(e.Path)
+	}
+	return msg
+}
+
+// Unwrap returns the sentinel error of the limit that was exceeded.
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// checkOutput returns a *LimitError if the size of o is more than max bytes.
+// There is no limit if max is zero. It is called once o has been manifested
+// in full.
+func checkOutput(o *Output, max int) error {
+	if max <= 0 {
+		return nil
+	}
+	size := 0
+	for _, doc := range o.Docs {
+		size += len(doc)
+	}
+	for _, f := range o.Files {
+		size += len(f)
+	}
+	if size > max {
+		return &LimitError{Err: ErrOutputLimit, Limit: max}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package jsonnext
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+
+	"foxygo.at/s/errs"
+	jsonnet "github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+)
+
+// Sentinel errors for native functions. ErrInvalidNative is returned when
+// registering a Go function that cannot be a native function.
+// ErrNativeArg is returned to jsonnet when a native function is called with an
+// argument that cannot be converted to the type of its Go parameter.
+var (
+	ErrInvalidNative = errors.New("invalid native function")
+	ErrNativeArg     = errors.New("invalid argument")
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem() //nolint:gochecknoglobals
+
+// NewNative returns a jsonnet native function named name that calls the Go
+// function fn, such as func(s string, n int) ([]string, error).
+//
+// fn must not be variadic and must return a single value, optionally followed
+// by an error. The jsonnet arguments are converted to the types of the
+// parameters of fn via their JSON encoding, so parameters may be of any type
+// that JSON can be unmarshaled into, including structs, slices and maps.
+// Likewise, the result is converted to a jsonnet value via its JSON encoding.
+// An argument that cannot be converted, or an error returned by fn, is turned
+// into a jsonnet runtime error.
+//
+// params names the parameters of the native function. As Go does not record
+// the names of function parameters, if params is empty the parameters are
+// named "arg0", "arg1" and so on. Otherwise there must be one name for each
+// parameter of fn.
+func NewNative(name string, fn interface{}, params ...string) (*jsonnet.NativeFunction, error) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func {
+		return nil, errs.Errorf("%v %s: %T is not a function", ErrInvalidNative, name, fn)
+	}
+	t := v.Type()
+	switch {
+	case t.IsVariadic():
+		return nil, errs.Errorf("%v %s: variadic functions are not supported", ErrInvalidNative, name)
+	case t.NumOut() == 0 || t.NumOut() > 2 || t.Out(0) == errorType:
+		return nil, errs.Errorf("%v %s: must return a value and an optional error", ErrInvalidNative, name)
+	case t.NumOut() == 2 && t.Out(1) != errorType:
+		return nil, errs.Errorf("%v %s: second return value must be an error", ErrInvalidNative, name)
+	case len(params) != 0 && len(params) != t.NumIn():
+		return nil, errs.Errorf("%v %s: %d params given for %d arguments", ErrInvalidNative, name, len(params), t.NumIn())
+	}
+
+	ids := make(ast.Identifiers, t.NumIn())
+	for i := range ids {
+		if len(params) != 0 {
+			ids[i] = ast.Identifier(params[i])
+		} else {
+			ids[i] = ast.Identifier("arg" + strconv.Itoa(i))
+		}
+	}
+
+	call := func(args []interface{}) (interface{}, error) {
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			val, err := fromJSONValue(arg, t.In(i))
+			if err != nil {
+				return nil, errs.Errorf("%v %s to %s: %v", ErrNativeArg, ids[i], name, err)
+			}
+			in[i] = val
+		}
+		out := v.Call(in)
+		if len(out) == 2 && !out[1].IsNil() {
+			return nil, out[1].Interface().(error)
+		}
+		return toJSONValue(out[0].Interface())
+	}
+	return &jsonnet.NativeFunction{Name: name, Params: ids, Func: call}, nil
+}
+
+// AddNative adds a native function to c that calls the Go function fn, as
+// described for NewNative. The natives in c are registered in a jsonnet VM by
+// ConfigureVM.
+func (c *Config) AddNative(name string, fn interface{}, params ...string) error {
+	nf, err := NewNative(name, fn, params...)
+	if err != nil {
+		return err
+	}
+	c.Natives = append(c.Natives, nf)
+	return nil
+}
+
+// fromJSONValue converts the jsonnet value v, as passed to a native function,
+// into a value of type t.
+func fromJSONValue(v interface{}, t reflect.Type) (reflect.Value, error) {
+	if v == nil {
+		switch t.Kind() { //nolint:exhaustive
+		case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+			return reflect.Zero(t), nil
+		}
+		return reflect.Value{}, errs.Errorf("null is not a %s", t)
+	}
+	if reflect.TypeOf(v).AssignableTo(t) {
+		return reflect.ValueOf(v), nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	p := reflect.New(t)
+	if err := json.Unmarshal(b, p.Interface()); err != nil {
+		return reflect.Value{}, err
+	}
+	return p.Elem(), nil
+}
+
+// toJSONValue converts the Go value v into the types used by jsonnet for the
+// result of a native function.
+func toJSONValue(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var result interface{}
+	if err := json.Unmarshal(b, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
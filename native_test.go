@@ -0,0 +1,103 @@
+package jsonnext
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type point struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+func TestNative(t *testing.T) {
+	repeat := func(s string, n int) ([]string, error) {
+		if n < 0 {
+			return nil, errors.New("negative count")
+		}
+		result := make([]string, n)
+		for i := range result {
+			result[i] = s
+		}
+		return result, nil
+	}
+	nf, err := NewNative("repeat", repeat)
+	require.NoError(t, err)
+	require.Equal(t, "repeat", nf.Name)
+	require.Equal(t, 2, len(nf.Params))
+	require.Equal(t, "arg0", string(nf.Params[0]))
+
+	got, err := nf.Func([]interface{}{"a", 2.0})
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{"a", "a"}, got)
+
+	_, err = nf.Func([]interface{}{"a", -1.0})
+	require.EqualError(t, err, "negative count")
+
+	_, err = nf.Func([]interface{}{"a", 1.5})
+	require.True(t, errors.Is(err, ErrNativeArg), "%v", err)
+
+	_, err = nf.Func([]interface{}{1.0, 1.0})
+	require.True(t, errors.Is(err, ErrNativeArg), "%v", err)
+
+	_, err = nf.Func([]interface{}{nil, 1.0})
+	require.True(t, errors.Is(err, ErrNativeArg), "%v", err)
+}
+
+func TestNativeConversion(t *testing.T) {
+	move := func(p point, by map[string]int, tags []string) point {
+		return point{X: p.X + by["x"], Y: p.Y + by["y"] + len(tags)}
+	}
+	nf, err := NewNative("move", move, "p", "by", "tags")
+	require.NoError(t, err)
+	require.Equal(t, "by", string(nf.Params[1]))
+
+	args := []interface{}{
+		map[string]interface{}{"x": 1.0, "y": 2.0},
+		map[string]interface{}{"x": 10.0},
+		nil,
+	}
+	got, err := nf.Func(args)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"x": 11.0, "y": 2.0}, got)
+
+	nf, err = NewNative("upper", strings.ToUpper)
+	require.NoError(t, err)
+	got, err = nf.Func([]interface{}{"abc"})
+	require.NoError(t, err)
+	require.Equal(t, "ABC", got)
+}
+
+func TestNativeInvalid(t *testing.T) {
+	tests := map[string]struct {
+		fn     interface{}
+		params []string
+	}{
+		"not a func":  {fn: "hello"},
+		"nil":         {fn: nil},
+		"variadic":    {fn: func(s ...string) string { return "" }},
+		"no result":   {fn: func(s string) {}},
+		"only error":  {fn: func(s string) error { return nil }},
+		"not error":   {fn: func(s string) (string, string) { return "", "" }},
+		"many result": {fn: func(s string) (string, string, error) { return "", "", nil }},
+		"params":      {fn: func(s string) string { return "" }, params: []string{"a", "b"}},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			_, err := NewNative("f", tc.fn, tc.params...)
+			require.True(t, errors.Is(err, ErrInvalidNative), "%v", err)
+		})
+	}
+}
+
+func TestConfigAddNative(t *testing.T) {
+	c := NewConfig()
+	require.NoError(t, c.AddNative("upper", strings.ToUpper, "s"))
+	require.Error(t, c.AddNative("bad", 1))
+	require.Equal(t, 1, len(c.Natives))
+	require.Equal(t, "upper", c.Natives[0].Name)
+}
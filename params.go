@@ -0,0 +1,305 @@
+package jsonnext
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"foxygo.at/s/errs"
+	jsonnet "github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+)
+
+// Sentinel errors for the problems found by Config.CheckTLAs and for files
+// without a top-level function.
+var (
+	ErrNotFunction = errors.New("top level is not a function")
+	ErrUnknownTLA  = errors.New("unknown top-level arg")
+	ErrMissingTLA  = errors.New("missing top-level arg")
+)
+
+// Param is a parameter of the top-level function of a jsonnet file.
+type Param struct {
+	Name string
+	// Default is the jsonnet source of the default value of the
+	// parameter, or empty if the parameter is required. Defaults that are
+	// not literals and whose source cannot be found are shown as "...".
+	Default string
+}
+
+// Required returns true if p has no default value.
+func (p Param) Required() bool {
+	return p.Default == ""
+}
+
+// FileParams parses the jsonnet file filename and returns the parameters of
+// its top-level function, in the order they are declared. Top-level locals
+// before the function are skipped. If the file does not evaluate directly to
+// a function, ErrNotFunction is returned.
+func FileParams(filename string) ([]Param, error) {
+	b, err := ioutil.ReadFile(filename) //nolint:gosec // We want to read user specified files.
+	if err != nil {
+		return nil, err
+	}
+	return SnippetParams(filename, string(b))
+}
+
+// SnippetParams parses snippet as jsonnet and returns the parameters of its
+// top-level function, as described for FileParams. filename is used in parse
+// errors.
+func SnippetParams(filename, snippet string) ([]Param, error) {
+	node, err := jsonnet.SnippetToAST(filename, snippet)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(snippet, "\n")
+	for {
+		switch n := node.(type) {
+		case *ast.Local:
+			node = n.Body
+			continue
+		case *ast.Function:
+			params := make([]Param, len(n.Parameters))
+			for i, p := range n.Parameters {
+				params[i] = Param{Name: string(p.Name)}
+				if p.DefaultArg != nil {
+					params[i].Default = nodeSource(p.DefaultArg, lines)
+				}
+			}
+			return params, nil
+		}
+		return nil, errs.Errorf("%v: %s", ErrNotFunction, filename)
+	}
+}
+
+// nodeSource returns the jsonnet source of node, formatting literals directly
+// and otherwise taking the source from lines using the location of node.
+func nodeSource(node ast.Node, lines []string) string {
+	switch n := node.(type) {
+	case *ast.LiteralString:
+		b, _ := json.Marshal(n.Value)
+		return string(b)
+	case *ast.LiteralNumber:
+		return n.OriginalString
+	case *ast.LiteralBoolean:
+		if n.Value {
+			return "true"
+		}
+		return "false"
+	case *ast.LiteralNull:
+		return "null"
+	}
+	// Locations are 1-based and the end is just past the node.
+	loc := node.Loc()
+	begin, end := loc.Begin, loc.End
+	if begin.Line < 1 || end.Line < begin.Line || end.Line > len(lines) {
+		return "..."
+	}
+	if begin.Column < 1 || begin.Column-1 > len(lines[begin.Line-1]) || end.Column < 1 || end.Column-1 > len(lines[end.Line-1]) {
+		return "..."
+	}
+	if begin.Line == end.Line {
+		if end.Column <= begin.Column {
+			return "..."
+		}
+		return lines[begin.Line-1][begin.Column-1 : end.Column-1]
+	}
+	src := []string{lines[begin.Line-1][begin.Column-1:]}
+	src = append(src, lines[begin.Line:end.Line-1]...)
+	src = append(src, lines[end.Line-1][:end.Column-1])
+	return strings.Join(src, "\n")
+}
+
+// SetParamArgs sets top-level args in m from command line style args for the
+// parameters params, such as returned by FileParams. Each arg is
+// "--name=value" or "--name value", where name is a parameter name, in which
+// underscores may also be written as dashes. A parameter with a boolean
+// default may be given as just "--name" to set it to true.
+//
+// Values are auto-typed as for VMVarMap.SetVarPath: a JSON number, true,
+// false or null is set as code and anything else as a string, so a quoted
+// value is always a string. An error is returned for an arg that is not a
+// parameter, with a suggestion for a likely typo, or is missing its value.
+func (m VMVarMap) SetParamArgs(params []Param, args []string) error {
+	byName := make(map[string]Param, 2*len(params))
+	names := make([]string, 0, len(params))
+	for _, p := range params {
+		byName[p.Name] = p
+		byName[strings.ReplaceAll(p.Name, "_", "-")] = p
+		names = append(names, p.Name)
+	}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "--") || arg == "--" {
+			return errs.Errorf(`%v: unexpected argument "%s"`, ErrInvalidValue, arg)
+		}
+		parts := strings.SplitN(arg[2:], "=", 2)
+		p, ok := byName[parts[0]]
+		if !ok {
+			if s := suggest(parts[0], names); s != "" {
+				return errs.Errorf("%v: %s (did you mean --%s?)", ErrUnknownTLA, arg, s)
+			}
+			return errs.Errorf("%v: %s", ErrUnknownTLA, arg)
+		}
+		var val string
+		switch {
+		case len(parts) == 2:
+			val = parts[1]
+		case (p.Default == "true" || p.Default == "false") && (i+1 == len(args) || strings.HasPrefix(args[i+1], "--")):
+			val = "true"
+		case i+1 < len(args):
+			i++
+			val = args[i]
+		default:
+			return errs.Errorf("%v: %s", ErrMissingValue, arg)
+		}
+		v := autoType(val)
+		if s, ok := v.(string); ok {
+			m[p.Name] = NewTLAStr(s)
+			continue
+		}
+		code, err := valueCode(v)
+		if err != nil {
+			return err
+		}
+		m[p.Name] = NewTLACode(code)
+	}
+	return nil
+}
+
+// TLAReport is the result of checking the TLAVars of a Config against the
+// parameters of a top-level function with Config.CheckTLAs. All names are
+// sorted.
+type TLAReport struct {
+	// Unknown holds the TLAs that are not parameters of the function.
+	Unknown []string
+	// Missing holds the required parameters that have no TLA.
+	Missing []string
+	// Defaulted holds the optional parameters that have no TLA and will
+	// use their default value.
+	Defaulted []string
+	// Suggestions maps unknown TLAs to the parameter with the closest
+	// name, if there is one close enough to be a likely typo.
+	Suggestions map[string]string
+}
+
+// Err returns a *ValidationError holding an error wrapping ErrUnknownTLA for
+// each unknown TLA, with a suggestion if there is one, and an error wrapping
+// ErrMissingTLA for each missing parameter. If there are none, nil is
+// returned. Defaulted parameters are not errors.
+func (r *TLAReport) Err() error {
+	e := &ValidationError{}
+	for _, name := range r.Unknown {
+		if s, ok := r.Suggestions[name]; ok {
+			e.Errs = append(e.Errs, errs.Errorf("%v: %s (did you mean %s?)", ErrUnknownTLA, name, s))
+		} else {
+			e.Errs = append(e.Errs, errs.Errorf("%v: %s", ErrUnknownTLA, name))
+		}
+	}
+	for _, name := range r.Missing {
+		e.Errs = append(e.Errs, errs.Errorf("%v: %s", ErrMissingTLA, name))
+	}
+	if len(e.Errs) == 0 {
+		return nil
+	}
+	return e
+}
+
+// CheckTLAs checks the TLAVars of c against params, the parameters of the
+// top-level function that will be evaluated, such as returned by FileParams.
+// go-jsonnet only reports these problems during evaluation, and an unknown
+// TLA is reported with an error that does not name the parameters.
+func (c *Config) CheckTLAs(params []Param) *TLAReport {
+	r := &TLAReport{Suggestions: map[string]string{}}
+	names := make([]string, len(params))
+	known := make(map[string]bool, len(params))
+	for i, p := range params {
+		names[i] = p.Name
+		known[p.Name] = true
+		if _, ok := c.TLAVars[p.Name]; ok {
+			continue
+		}
+		if p.Required() {
+			r.Missing = append(r.Missing, p.Name)
+		} else {
+			r.Defaulted = append(r.Defaulted, p.Name)
+		}
+	}
+	for _, name := range sortedNames(c.TLAVars) {
+		if known[name] {
+			continue
+		}
+		r.Unknown = append(r.Unknown, name)
+		if s := suggest(name, names); s != "" {
+			r.Suggestions[name] = s
+		}
+	}
+	sort.Strings(r.Missing)
+	sort.Strings(r.Defaulted)
+	return r
+}
+
+// suggest returns the name in names closest to name if it is likely that
+// name is a typo of it, or empty if there is none. A name is likely a typo if
+// it is within an edit distance of a third of its length (at least 1), or if
+// one is a prefix of the other.
+func suggest(name string, names []string) string {
+	best, bestDist := "", len(name)/3
+	if bestDist < 1 {
+		bestDist = 1
+	}
+	for _, n := range names {
+		d := editDistance(name, n)
+		if d <= bestDist && (best == "" || d < bestDist) {
+			best, bestDist = n, d
+		}
+	}
+	if best != "" {
+		return best
+	}
+	for _, n := range names {
+		if len(name) >= 3 && len(n) >= 3 && (strings.HasPrefix(n, name) || strings.HasPrefix(name, n)) {
+			return n
+		}
+	}
+	return ""
+}
+
+// editDistance returns the edit distance between a and b, counting an
+// insertion, deletion, substitution or transposition of adjacent characters
+// as one edit.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = minInt(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
+
+func minInt(n int, ns ...int) int {
+	for _, m := range ns {
+		if m < n {
+			n = m
+		}
+	}
+	return n
+}
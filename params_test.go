@@ -0,0 +1,119 @@
+package jsonnext
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileParams(t *testing.T) {
+	params, err := FileParams("testdata/params/deploy.jsonnet")
+	require.NoError(t, err)
+	expected := []Param{
+		{Name: "env"},
+		{Name: "replicas", Default: "1"},
+		{Name: "image", Default: "defaults.image"},
+		{Name: "debug", Default: "false"},
+		{Name: "name", Default: `"app"`},
+	}
+	require.Equal(t, expected, params)
+
+	_, err = FileParams("testdata/params/object.jsonnet")
+	require.True(t, errors.Is(err, ErrNotFunction), "%v", err)
+	_, err = FileParams("testdata/params/missing.jsonnet")
+	require.Error(t, err)
+}
+
+func TestCheckTLAs(t *testing.T) {
+	params := []Param{
+		{Name: "env"},
+		{Name: "region"},
+		{Name: "replicas", Default: "1"},
+		{Name: "image", Default: `"nginx"`},
+	}
+	c := NewConfig()
+	c.TLAVars["enviroment"] = NewTLAStr("prod")
+	c.TLAVars["replica"] = NewTLACode("3")
+	c.TLAVars["region"] = NewTLAStr("eu")
+	c.TLAVars["zzz"] = NewTLAStr("")
+
+	r := c.CheckTLAs(params)
+	expected := &TLAReport{
+		Unknown:     []string{"enviroment", "replica", "zzz"},
+		Missing:     []string{"env"},
+		Defaulted:   []string{"image", "replicas"},
+		Suggestions: map[string]string{"enviroment": "env", "replica": "replicas"},
+	}
+	require.Equal(t, expected, r)
+
+	err := r.Err()
+	require.True(t, errors.Is(err, ErrUnknownTLA), "%v", err)
+	require.True(t, errors.Is(err, ErrMissingTLA), "%v", err)
+	require.Equal(t, `invalid config:
+  unknown top-level arg: enviroment (did you mean env?)
+  unknown top-level arg: replica (did you mean replicas?)
+  unknown top-level arg: zzz
+  missing top-level arg: env`, err.Error())
+
+	c = NewConfig()
+	c.TLAVars["env"] = NewTLAStr("prod")
+	c.TLAVars["region"] = NewTLAStr("eu")
+	r = c.CheckTLAs(params)
+	require.Empty(t, r.Unknown)
+	require.Empty(t, r.Missing)
+	require.NoError(t, r.Err())
+}
+
+func TestSetParamArgs(t *testing.T) {
+	params := []Param{
+		{Name: "env"},
+		{Name: "replicas", Default: "1"},
+		{Name: "debug", Default: "false"},
+		{Name: "dry_run", Default: "true"},
+		{Name: "version", Default: `"latest"`},
+	}
+	m := VMVarMap{}
+	args := []string{"--env", "prod", "--replicas=3", "--debug", "--dry-run=false", "--version", `"1.0"`}
+	require.NoError(t, m.SetParamArgs(params, args))
+	expected := VMVarMap{
+		"env":      NewTLAStr("prod"),
+		"replicas": NewTLACode("3"),
+		"debug":    NewTLACode("true"),
+		"dry_run":  NewTLACode("false"),
+		"version":  NewTLAStr("1.0"),
+	}
+	require.Equal(t, expected, m)
+
+	m = VMVarMap{}
+	require.NoError(t, m.SetParamArgs(params, []string{"--replicas", "-1", "--dry_run", "--debug"}))
+	require.Equal(t, VMVarMap{"replicas": NewTLACode("-1"), "dry_run": NewTLACode("true"), "debug": NewTLACode("true")}, m)
+
+	err := m.SetParamArgs(params, []string{"--replica=2"})
+	require.True(t, errors.Is(err, ErrUnknownTLA), "%v", err)
+	require.Contains(t, err.Error(), "did you mean --replicas?")
+	err = m.SetParamArgs(params, []string{"--env"})
+	require.True(t, errors.Is(err, ErrMissingValue), "%v", err)
+	err = m.SetParamArgs(params, []string{"prod"})
+	require.True(t, errors.Is(err, ErrInvalidValue), "%v", err)
+	err = m.SetParamArgs(params, []string{"--", "--env=prod"})
+	require.True(t, errors.Is(err, ErrInvalidValue), "%v", err)
+}
+
+func TestSuggest(t *testing.T) {
+	names := []string{"env", "replicas", "image", "namespace"}
+	tests := map[string]string{
+		"evn":         "env",
+		"replics":     "replicas",
+		"imgae":       "image",
+		"namespaces":  "namespace",
+		"ns":          "",
+		"x":           "",
+		"environment": "env",
+	}
+	for name, expected := range tests {
+		require.Equal(t, expected, suggest(name, names), name)
+	}
+	require.Equal(t, 3, editDistance("kitten", "sitting"))
+	require.Equal(t, 0, editDistance("", ""))
+}
@@ -0,0 +1,97 @@
+// Package pflag parses command line flags into jsonnext.Config using pflag.
+//
+//   import "github.com/spf13/pflag"
+//
+// pflag is a drop-in replacement for the Go flag package implementing
+// POSIX/GNU-style --flags, and is the flag package used by cobra. This
+// package defines the same flags as jsonnext.ConfigFlagsVar, with the short
+// flags as shorthands of their long flags, such as -J for --jpath.
+//
+// With cobra, define the flags on the flag set of a command and load the
+// environment before running it:
+//
+//   cfg := jnxpflag.ConfigFlags(cmd.Flags())
+//   cmd.PreRunE = func(*cobra.Command, []string) error {
+//       return cfg.LoadEnv(jsonnext.EnvPrefix)
+//   }
+//
+// This functionality is split into a separate sub-package so users of jsonnext
+// do not need to depend on pflag if they do not use it.
+package pflag
+
+import (
+	"flag"
+
+	"foxygo.at/jsonnext"
+	"github.com/spf13/pflag"
+)
+
+// shorthands maps the long flags defined by jsonnext.ConfigFlagsVar to the
+// short flags it also defines for them.
+var shorthands = map[string]string{
+	"jpath":   "J",
+	"ext-str": "V",
+	"tla-str": "A",
+	"ext-obj": "O",
+}
+
+// ConfigFlags defines a set of flags in the given FlagSet for a Config struct
+// to populate its fields from the command line. The return value is a pointer
+// to the Config struct that stores the values of the flags. The flags are
+// those described for jsonnext.ConfigFlags, with two dashes for long flags
+// and the short flags -J, -V, -A and -O as shorthands of --jpath, --ext-str,
+// --tla-str and --ext-obj.
+func ConfigFlags(fs *pflag.FlagSet) *jsonnext.Config {
+	c := jsonnext.NewConfig()
+	ConfigFlagsVar(fs, c)
+	return c
+}
+
+// ConfigFlagsVar defines a set of flags in the given FlagSet for a Config
+// struct to populate the fields from the command line. The argument c points
+// to the Config struct to populate. The set of flags defined is described in
+// the ConfigFlags function description.
+//
+// pflag has no hook to run after parsing, so to fill fields not set on the
+// command line from the environment, call c.LoadEnv(jsonnext.EnvPrefix) after
+// the FlagSet has been parsed.
+func ConfigFlagsVar(fs *pflag.FlagSet, c *jsonnext.Config) {
+	// Define the flags with the flag package and convert them, so the
+	// values are parsed exactly as they are by jsonnext.ConfigFlagsVar.
+	gfs := flag.NewFlagSet("", flag.ContinueOnError)
+	jsonnext.ConfigFlagsVar(gfs, c)
+	gfs.VisitAll(func(f *flag.Flag) {
+		if len(f.Name) == 1 {
+			// Short flags are added as shorthands of long flags.
+			return
+		}
+		pf := pflag.PFlagFromGoFlag(f)
+		pf.Shorthand = shorthands[f.Name]
+		pf.Value = value{f.Value, pf.Value.Type()}
+		pf.DefValue = pf.Value.String()
+		fs.AddFlag(pf)
+	})
+}
+
+// value adapts a flag.Value to a pflag.Value with the given type name.
+type value struct {
+	flag.Value
+	typ string
+}
+
+// Type returns the type name of v, shown in the usage of flags without a
+// back-quoted name. It implements the pflag.Value interface.
+func (v value) Type() string {
+	return v.typ
+}
+
+// String returns a string representation of v, or empty if v is empty, so
+// that pflag does not show empty values as defaults in the usage.
+func (v value) String() string {
+	s := v.Value.String()
+	switch s {
+	case "[]", "map[]", "0s":
+		return ""
+	}
+	return s
+}
@@ -0,0 +1,27 @@
+package pflag_test
+
+import (
+	"testing"
+
+	"github.com/spf13/pflag"
+
+	"foxygo.at/jsonnext"
+	"foxygo.at/jsonnext/conformance"
+	jnxpflag "foxygo.at/jsonnext/pflag"
+)
+
+type suite struct{}
+
+func (s *suite) Parse(t *testing.T, args []string) (*jsonnext.Config, error) {
+	fs := pflag.NewFlagSet(args[0], pflag.ContinueOnError)
+	cfg := jnxpflag.ConfigFlags(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		return nil, err
+	}
+	return cfg, cfg.LoadEnv(jsonnext.EnvPrefix)
+}
+
+func TestConformance(t *testing.T) {
+	s := conformance.NewSuite(&suite{})
+	s.Run(t)
+}
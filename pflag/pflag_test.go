@@ -0,0 +1,37 @@
+package pflag
+
+import (
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShorthands(t *testing.T) {
+	fs := pflag.NewFlagSet(t.Name(), pflag.ContinueOnError)
+	ConfigFlags(fs)
+	for name, short := range shorthands {
+		f := fs.Lookup(name)
+		require.NotNil(t, f, name)
+		require.Equal(t, short, f.Shorthand)
+		require.Nil(t, fs.Lookup(short), "short flag %s should only be a shorthand", short)
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	fs := pflag.NewFlagSet(t.Name(), pflag.ContinueOnError)
+	ConfigFlags(fs)
+	require.Equal(t, "500", fs.Lookup("max-stack").DefValue)
+	require.Equal(t, "0", fs.Lookup("max-output").DefValue)
+	require.Equal(t, "", fs.Lookup("timeout").DefValue)
+	require.Equal(t, "", fs.Lookup("jpath").DefValue)
+	require.Equal(t, "", fs.Lookup("ext-str").DefValue)
+	require.Equal(t, "duration", fs.Lookup("timeout").Value.Type())
+
+	usage := fs.FlagUsages()
+	require.Contains(t, usage, "-J, --jpath dir")
+	require.Contains(t, usage, "-V, --ext-str var[=str]")
+	require.Contains(t, usage, "(default 500)")
+	require.NotContains(t, usage, "(default )")
+	require.NotContains(t, usage, "map[]")
+}
@@ -0,0 +1,311 @@
+// Package proxy implements a caching HTTP proxy server for jsonnext netpath
+// imports.
+//
+// A netpath such as //github.com/org/repo/raw/master/lib.libsonnet is served by
+// the proxy at the URL path /github.com/org/repo/raw/master/lib.libsonnet. The
+// content is fetched through a jsonnext.Importer and cached on disk, so that
+// many clients importing the same netpaths only fetch them from the origin
+// once.
+//
+// Clients use the proxy by setting the Fetcher of their jsonnext.Importer to a
+// proxy.Fetcher with the base URL of the proxy.
+//
+// The proxy also serves two administrative endpoints:
+//  GET /_stats: JSON encoded cache statistics (Stats)
+//  POST /_purge: remove all cached content
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"foxygo.at/jsonnext"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	statsPath = "/_stats"
+	purgePath = "/_purge"
+
+	// contentFile is the name of the file holding the content of a netpath
+	// in the directory for it. Netpaths with an element starting with "."
+	// are rejected, so it cannot clash with another netpath.
+	contentFile = ".content"
+)
+
+// Stats holds counters of the requests served by a Server since it was
+// started.
+type Stats struct {
+	Hits     int `json:"hits"`
+	Misses   int `json:"misses"`
+	NotFound int `json:"notFound"`
+	Errors   int `json:"errors"`
+	Purges   int `json:"purges"`
+}
+
+// Server is a http.Handler that serves netpath content fetched through a
+// jsonnext.Importer and cached in a directory on disk.
+type Server struct {
+	// Dir is the directory in which fetched content is cached. Each
+	// netpath is stored in a file named ".content" in the directory at the
+	// same relative path under Dir, so that both a netpath and netpaths
+	// below it, such as //host/a and //host/a/b, can be cached.
+	Dir string
+
+	// Importer is used to fetch content not in the cache. Each fetch uses
+	// a new Importer with the exported fields of Importer, so that fetches
+	// of different netpaths can run concurrently with their own import
+	// cache and limits. Concurrent requests for the same netpath share one
+	// fetch.
+	Importer *jsonnext.Importer
+
+	fetches singleflight.Group
+	mu      sync.Mutex // guards stats and the contents of Dir
+	stats   Stats
+}
+
+// NewServer returns a Server that caches content in dir and fetches it using
+// importer.
+func NewServer(dir string, importer *jsonnext.Importer) *Server {
+	return &Server{Dir: dir, Importer: importer}
+}
+
+// ServeHTTP serves the netpath content, stats or purge request in r. It
+// implements the http.Handler interface.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method := http.MethodGet
+	if r.URL.Path == purgePath {
+		method = http.MethodPost
+	}
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	switch r.URL.Path {
+	case statsPath:
+		s.serveStats(w)
+	case purgePath:
+		s.servePurge(w)
+	default:
+		s.serveNetpath(w, r)
+	}
+}
+
+// Stats returns a snapshot of the statistics of s.
+func (s *Server) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// Purge removes all content from the disk cache.
+func (s *Server) Purge() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Purges++
+	entries, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(s.Dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) serveStats(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.Stats())
+}
+
+func (s *Server) servePurge(w http.ResponseWriter) {
+	if err := s.Purge(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) serveNetpath(w http.ResponseWriter, r *http.Request) {
+	p := path.Clean("/" + r.URL.Path)
+	if !validNetpath(p) {
+		http.Error(w, "invalid netpath", http.StatusBadRequest)
+		return
+	}
+	b, err := s.get(p)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		http.NotFound(w, r)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write(b)
+	}
+}
+
+// validNetpath returns true if the cleaned URL path p names a netpath that can
+// be cached: it is not the root and none of its elements start with ".", so
+// it cannot name the content files or temporary files in the cache.
+func validNetpath(p string) bool {
+	if p == "/" {
+		return false
+	}
+	for _, elem := range strings.Split(p[1:], "/") {
+		if strings.HasPrefix(elem, ".") {
+			return false
+		}
+	}
+	return true
+}
+
+// get returns the content of the netpath "/"+p from the disk cache, or
+// fetches it with the Importer and writes it to the disk cache if it is not
+// there. If the netpath does not exist, an error wrapping os.ErrNotExist is
+// returned. The lock is not held while fetching, so a slow fetch does not
+// block other requests.
+func (s *Server) get(p string) ([]byte, error) {
+	filename := filepath.Join(s.Dir, filepath.FromSlash(p), contentFile)
+	s.mu.Lock()
+	b, err := ioutil.ReadFile(filename) //nolint:gosec // filename is cleaned and rooted at Dir
+	if err == nil {
+		s.stats.Hits++
+	} else {
+		s.stats.Misses++
+	}
+	s.mu.Unlock()
+	if err == nil {
+		return b, nil
+	}
+
+	v, err, _ := s.fetches.Do(p, func() (interface{}, error) {
+		return s.fetch(p, filename)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]byte), nil
+}
+
+// fetch fetches the netpath "/"+p with a new Importer and writes it to the
+// disk cache at filename.
+func (s *Server) fetch(p, filename string) ([]byte, error) {
+	contents, _, err := s.newImporter().Import("", "/"+p)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		if errors.Is(err, jsonnext.ErrNotFound) {
+			s.stats.NotFound++
+			return nil, os.ErrNotExist
+		}
+		s.stats.Errors++
+		return nil, err
+	}
+
+	b := []byte(contents.String())
+	if err := writeFile(filename, b); err != nil {
+		s.stats.Errors++
+		return nil, err
+	}
+	return b, nil
+}
+
+// newImporter returns a new Importer with the exported fields of the Importer
+// of s. Its import cache and the counts for its limits start empty.
+func (s *Server) newImporter() *jsonnext.Importer {
+	i := s.Importer
+	return &jsonnext.Importer{
+		SearchPath:     i.SearchPath,
+		Fetcher:        i.Fetcher,
+		DiscoverDirs:   i.DiscoverDirs,
+		RootMarkers:    i.RootMarkers,
+		DiscoverFirst:  i.DiscoverFirst,
+		Bundle:         i.Bundle,
+		Signatures:     i.Signatures,
+		Context:        i.Context,
+		MaxImports:     i.MaxImports,
+		MaxImportBytes: i.MaxImportBytes,
+	}
+}
+
+// writeFile atomically writes b to filename, creating any parent directories
+// as needed.
+func writeFile(filename string, b []byte) error {
+	dir := filepath.Dir(filename)
+	if err := os.MkdirAll(dir, 0o755); err != nil { //nolint:gomnd
+		return err
+	}
+	f, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), filename)
+}
+
+// Fetcher is a jsonnext.URLFetcher that fetches https URLs through a proxy
+// Server. Set it as the Fetcher of a jsonnext.Importer to import netpaths
+// via the proxy.
+type Fetcher struct {
+	// BaseURL is the URL of the proxy Server, such as
+	// "http://jnx-proxy.example.com:8080".
+	BaseURL string
+
+	// Client is used to make requests to the proxy. The default is
+	// &http.Client{}.
+	Client jsonnext.URLFetcher
+}
+
+// Get fetches url through the proxy. It implements the jsonnext.URLFetcher
+// interface.
+func (f *Fetcher) Get(url string) (*http.Response, error) {
+	return f.client().Get(f.proxyURL(url))
+}
+
+// Do makes the GET request req through the proxy, so that an Importer with a
+// Context can cancel it. If the Client of f does not support requests, the
+// Context of req is ignored.
+func (f *Fetcher) Do(req *http.Request) (*http.Response, error) {
+	client := f.client()
+	d, ok := client.(interface {
+		Do(*http.Request) (*http.Response, error)
+	})
+	if !ok {
+		return client.Get(f.proxyURL(req.URL.String()))
+	}
+	preq, err := http.NewRequestWithContext(req.Context(), req.Method, f.proxyURL(req.URL.String()), nil)
+	if err != nil {
+		return nil, err
+	}
+	return d.Do(preq)
+}
+
+func (f *Fetcher) client() jsonnext.URLFetcher {
+	if f.Client == nil {
+		return &http.Client{}
+	}
+	return f.Client
+}
+
+func (f *Fetcher) proxyURL(url string) string {
+	return strings.TrimSuffix(f.BaseURL, "/") + "/" + strings.TrimPrefix(url, "https://")
+}
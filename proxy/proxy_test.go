@@ -0,0 +1,238 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"foxygo.at/jsonnext"
+)
+
+type requestCounter struct {
+	count int
+	next  http.Handler
+}
+
+func (rc *requestCounter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.count++
+	rc.next.ServeHTTP(w, r)
+}
+
+// setup starts an origin server serving the jsonnext testdata directory and a
+// proxy server in front of it caching into a temporary directory. The returned
+// Importer imports through the proxy.
+func setup(t *testing.T) (*jsonnext.Importer, *Server, *requestCounter, string, func()) {
+	t.Helper()
+	origin := &requestCounter{next: http.FileServer(http.Dir("../testdata"))}
+	originServer := httptest.NewTLSServer(origin)
+	dir, err := ioutil.TempDir("", "jnx-proxy-test-")
+	require.NoError(t, err)
+
+	srv := NewServer(dir, &jsonnext.Importer{Fetcher: originServer.Client()})
+	ps := httptest.NewServer(srv)
+	i := &jsonnext.Importer{Fetcher: &Fetcher{BaseURL: ps.URL, Client: ps.Client()}}
+	np := strings.TrimPrefix(originServer.URL, "https:")
+
+	cleanup := func() {
+		ps.Close()
+		originServer.Close()
+		_ = os.RemoveAll(dir)
+	}
+	return i, srv, origin, np, cleanup
+}
+
+func TestProxy(t *testing.T) {
+	i, srv, origin, np, cleanup := setup(t)
+	defer cleanup()
+
+	contents, foundAt, err := i.Import("", np+"/importer/hello.txt")
+	require.NoError(t, err)
+	require.Equal(t, "hello world\n", contents.String())
+	require.Equal(t, np+"/importer/hello.txt", foundAt)
+	require.Equal(t, 1, origin.count)
+
+	b, err := ioutil.ReadFile(filepath.Join(srv.Dir, strings.TrimPrefix(np, "//"), "importer", "hello.txt", contentFile))
+	require.NoError(t, err)
+	require.Equal(t, "hello world\n", string(b))
+
+	// A new client importer should be served from the proxy cache.
+	i = &jsonnext.Importer{Fetcher: i.Fetcher}
+	contents, _, err = i.Import("", np+"/importer/hello.txt")
+	require.NoError(t, err)
+	require.Equal(t, "hello world\n", contents.String())
+	require.Equal(t, 1, origin.count)
+	require.Equal(t, Stats{Hits: 1, Misses: 1}, srv.Stats())
+}
+
+func TestProxyNotFound(t *testing.T) {
+	i, srv, _, np, cleanup := setup(t)
+	defer cleanup()
+
+	_, _, err := i.Import("", np+"/importer/notfound.txt")
+	require.Error(t, err)
+	require.True(t, errors.Is(err, jsonnext.ErrNotFound), "error should be jsonnext.ErrNotFound: %v", err)
+	require.Equal(t, Stats{Misses: 1, NotFound: 1}, srv.Stats())
+}
+
+func TestProxyError(t *testing.T) {
+	i, srv, _, np, cleanup := setup(t)
+	defer cleanup()
+
+	_, _, err := i.Import("", np+"/importer/ELOOP")
+	require.Error(t, err)
+	require.Equal(t, Stats{Misses: 1, Errors: 1}, srv.Stats())
+}
+
+func TestProxyStatsAndPurge(t *testing.T) {
+	i, srv, origin, np, cleanup := setup(t)
+	defer cleanup()
+	ps := httptest.NewServer(srv)
+	defer ps.Close()
+
+	_, _, err := i.Import("", np+"/importer/hello.txt")
+	require.NoError(t, err)
+
+	resp, err := ps.Client().Post(ps.URL+"/_purge", "", nil)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	entries, err := ioutil.ReadDir(srv.Dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	// After a purge, content is fetched from the origin again.
+	i = &jsonnext.Importer{Fetcher: i.Fetcher}
+	_, _, err = i.Import("", np+"/importer/hello.txt")
+	require.NoError(t, err)
+	require.Equal(t, 2, origin.count)
+
+	resp, err = ps.Client().Get(ps.URL + "/_stats")
+	require.NoError(t, err)
+	defer resp.Body.Close() //nolint:errcheck
+	var stats Stats
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&stats))
+	require.Equal(t, Stats{Misses: 2, Purges: 1}, stats)
+}
+
+func TestProxyBadRequest(t *testing.T) {
+	_, srv, _, _, cleanup := setup(t)
+	defer cleanup()
+
+	tests := map[string]struct {
+		method, path string
+		status       int
+	}{
+		"root":    {http.MethodGet, "/", http.StatusBadRequest},
+		"hidden":  {http.MethodGet, "/.tmp-123", http.StatusBadRequest},
+		"nested":  {http.MethodGet, "/example.com/x/.tmp-123", http.StatusBadRequest},
+		"content": {http.MethodGet, "/example.com/x/.content", http.StatusBadRequest},
+		"method":  {http.MethodPut, "/example.com/x.libsonnet", http.StatusMethodNotAllowed},
+		"purge":   {http.MethodGet, "/_purge", http.StatusMethodNotAllowed},
+		"stats":   {http.MethodPost, "/_stats", http.StatusMethodNotAllowed},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+			require.Equal(t, tc.status, w.Code)
+		})
+	}
+}
+
+// Test that a netpath and a netpath below it can both be cached, whichever is
+// fetched first.
+func TestProxyNested(t *testing.T) {
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.Path))
+	}))
+	defer origin.Close()
+	host := "/" + strings.TrimPrefix(origin.URL, "https://")
+
+	for name, paths := range map[string][]string{
+		"parent first": {"/a", "/a/b", "/a"},
+		"child first":  {"/c/d", "/c", "/c/d"},
+	} {
+		paths := paths
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "jnx-proxy-test-")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir) //nolint:errcheck
+			srv := NewServer(dir, &jsonnext.Importer{Fetcher: origin.Client()})
+			for _, p := range paths {
+				w := httptest.NewRecorder()
+				srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, host+p, nil))
+				require.Equal(t, http.StatusOK, w.Code, "%s: %s", p, w.Body)
+				require.Equal(t, p, w.Body.String())
+			}
+			require.Equal(t, Stats{Hits: 1, Misses: 2}, srv.Stats())
+		})
+	}
+}
+
+// Test that a slow fetch does not block other requests, and that concurrent
+// requests for the same netpath share one fetch from the origin.
+func TestProxyConcurrent(t *testing.T) {
+	var count int32
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&count, 1) == 1 {
+			started <- struct{}{}
+		}
+		<-release
+		_, _ = w.Write([]byte("slow\n"))
+	}))
+	defer origin.Close()
+	dir, err := ioutil.TempDir("", "jnx-proxy-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) //nolint:errcheck
+	srv := NewServer(dir, &jsonnext.Importer{Fetcher: origin.Client()})
+	p := "/" + strings.TrimPrefix(origin.URL, "https://") + "/slow.libsonnet"
+
+	const n = 3
+	var wg sync.WaitGroup
+	codes := make([]int, n)
+	for j := 0; j < n; j++ {
+		wg.Add(1)
+		go func(j int) {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, p, nil))
+			codes[j] = w.Code
+		}(j)
+	}
+	<-started
+	for srv.Stats().Misses < n {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond) // let the requests join the fetch
+
+	done := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/_stats", nil))
+		done <- w.Code
+	}()
+	select {
+	case code := <-done:
+		require.Equal(t, http.StatusOK, code)
+	case <-time.After(5 * time.Second):
+		t.Fatal("stats request blocked by fetch")
+	}
+
+	close(release)
+	wg.Wait()
+	require.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusOK}, codes)
+	require.Equal(t, int32(1), atomic.LoadInt32(&count))
+}
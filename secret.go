@@ -0,0 +1,122 @@
+package jsonnext
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"foxygo.at/s/errs"
+	jsonnet "github.com/google/go-jsonnet"
+)
+
+// Redacted is shown in place of the value of a secret VMVar.
+const Redacted = "[redacted]"
+
+// ErrSecretVar is returned when a Config holding a secret var is marshalled
+// to JSON, or turned into command line arguments with Args when the source
+// of the secret value is not known.
+var ErrSecretVar = errors.New("secret var cannot be written out")
+
+// NewSecret wraps the VMVar v so that its value is only used to set it in a
+// jsonnet VM. Everywhere else the value is replaced by Redacted: Value(),
+// String() and GoString() return it, so it is redacted when a VMVarMap is
+// printed and in errors from Config.Check.
+//
+// A Config holding a secret var cannot be marshalled to JSON. Args returns
+// the secret var flags with the original source of the vars set by
+// SetSecretVar, so that they read their value again, and an error for
+// those made with NewSecret directly.
+//
+// The value of a secret code var may still show in jsonnet runtime errors
+// that quote the code of the var, so secret strings are preferred.
+func NewSecret(v VMVar) VMVar {
+	if IsSecret(v) {
+		return v
+	}
+	return secretVar{v: v}
+}
+
+// IsSecret returns true if v was constructed with NewSecret.
+func IsSecret(v VMVar) bool {
+	_, ok := v.(secretVar)
+	return ok
+}
+
+type secretVar struct {
+	v VMVar
+	// arg is the "key[=source]" string SetSecretVar parsed, or empty if
+	// the source of the value is not known.
+	arg string
+}
+
+func (s secretVar) Set(key string, vm *jsonnet.VM) { s.v.Set(key, vm) }
+func (s secretVar) Kind() VMVarKind                { return s.v.Kind() }
+func (s secretVar) Value() string                  { return Redacted }
+func (s secretVar) String() string                 { return Redacted }
+func (s secretVar) GoString() string               { return Redacted }
+
+// reveal returns the VMVar wrapped by v if it is secret, and v otherwise.
+func reveal(v VMVar) VMVar {
+	if s, ok := v.(secretVar); ok {
+		return s.v
+	}
+	return v
+}
+
+// SetSecretVar sets a secret variable in m, parsing the key and the source of
+// its value from the given string v, and using makevar to construct the VMVar
+// that NewSecret wraps. v is parsed as "key[=source]" so that the value
+// itself does not appear on the command line, where it could be seen by other
+// users with ps. The value is read from:
+//  - the environment variable named key, if source is omitted
+//  - the file descriptor N, if source is "fd:N", on systems with /dev/fd
+//  - the file named source, otherwise
+//
+// A single trailing newline is removed from a value read from a file or file
+// descriptor. An error is returned if the key is empty, the environment
+// variable does not exist, or the file or file descriptor cannot be read.
+func (m VMVarMap) SetSecretVar(v string, makevar func(string) VMVar) error {
+	parts := strings.SplitN(v, "=", 2)
+	if parts[0] == "" {
+		return errs.Errorf(`%v in "%s"`, ErrMissingKey, v)
+	}
+	var val string
+	if len(parts) == 1 {
+		var ok bool
+		if val, ok = os.LookupEnv(parts[0]); !ok {
+			return errs.Errorf("%v: %s", ErrMissingValue, parts[0])
+		}
+	} else {
+		var err error
+		if val, err = readSecret(parts[1]); err != nil {
+			return errs.Errorf("secret %s: %v", parts[0], err)
+		}
+	}
+	m[parts[0]] = secretVar{v: makevar(val), arg: v}
+	return nil
+}
+
+// readSecret reads a secret value from the file or file descriptor source, as
+// described for SetSecretVar. A file descriptor is read by opening it again
+// through /dev/fd, so the original descriptor is left open.
+func readSecret(source string) (string, error) {
+	filename := source
+	if strings.HasPrefix(source, "fd:") {
+		fd, err := strconv.Atoi(strings.TrimPrefix(source, "fd:"))
+		if err != nil || fd < 0 {
+			return "", errs.Errorf("%v: invalid file descriptor %#v", ErrInvalidValue, source)
+		}
+		filename = "/dev/fd/" + strconv.Itoa(fd)
+	}
+	b, err := ioutil.ReadFile(filename) //nolint:gosec // We want to read user specified files.
+	if err != nil {
+		return "", err
+	}
+	s := string(b)
+	if strings.HasSuffix(s, "\r\n") {
+		return strings.TrimSuffix(s, "\r\n"), nil
+	}
+	return strings.TrimSuffix(s, "\n"), nil
+}
@@ -0,0 +1,96 @@
+package jsonnext
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"foxygo.at/s/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretRedacted(t *testing.T) {
+	c := NewConfig()
+	c.ExtVars["token"] = NewSecret(NewExtStr("s3cret"))
+	c.TLAVars["key"] = NewSecret(NewSecret(NewTLACode("'s3cret'")))
+	require.Equal(t, NewSecret(NewTLACode("'s3cret'")), c.TLAVars["key"])
+	require.True(t, IsSecret(c.ExtVars["token"]))
+	require.False(t, IsSecret(NewExtStr("s3cret")))
+	require.Equal(t, KindExtStr, c.ExtVars["token"].Kind())
+	require.Equal(t, Redacted, c.ExtVars["token"].Value())
+	require.Equal(t, NewTLACode("'s3cret'"), reveal(c.TLAVars["key"]))
+
+	_, err := json.Marshal(c)
+	require.True(t, errors.Is(err, ErrSecretVar), "%v", err)
+	require.NotContains(t, err.Error(), "s3cret")
+	_, err = c.Args()
+	require.True(t, errors.Is(err, ErrSecretVar), "%v", err)
+	require.NotContains(t, err.Error(), "s3cret")
+
+	outputs := []string{
+		fmt.Sprint(c.ExtVars),
+		fmt.Sprintf("%v %+v %#v %s %q", c, c, c, c.TLAVars, c.TLAVars),
+	}
+	for _, out := range outputs {
+		require.NotContains(t, out, "s3cret")
+		require.Contains(t, out, Redacted)
+	}
+
+	c.ExtVars["code"] = NewSecret(NewExtCode("{ s3cret"))
+	err = c.Check()
+	require.True(t, errors.Is(err, ErrInvalidCode), "%v", err)
+	require.NotContains(t, err.Error(), "s3cret")
+}
+
+func TestSetSecretVar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jnx-secret-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) //nolint:errcheck
+	filename := filepath.Join(dir, "token")
+	require.NoError(t, ioutil.WriteFile(filename, []byte("from file\r\n"), 0o600))
+	test.Env.Set("token", "from env")
+	defer test.Env.Restore()
+
+	m := VMVarMap{}
+	require.NoError(t, m.SetSecretVar("file="+filename, NewExtStr))
+	require.NoError(t, m.SetSecretVar("token", NewExtCode))
+	expected := VMVarMap{
+		"file":  secretVar{v: NewExtStr("from file"), arg: "file=" + filename},
+		"token": secretVar{v: NewExtCode("from env"), arg: "token"},
+	}
+	require.Equal(t, expected, m)
+
+	c := NewConfig()
+	c.ExtVars = m
+	args, err := c.Args()
+	require.NoError(t, err)
+	require.Equal(t, []string{"--ext-str-secret=file=" + filename, "--ext-code-secret=token", "--max-stack=500", "--max-trace=20"}, args)
+
+	require.True(t, errors.Is(m.SetSecretVar("=x", NewExtStr), ErrMissingKey))
+	test.Env.Unset("missing")
+	require.True(t, errors.Is(m.SetSecretVar("missing", NewExtStr), ErrMissingValue))
+	require.Error(t, m.SetSecretVar("x="+filepath.Join(dir, "missing"), NewExtStr))
+	require.True(t, errors.Is(m.SetSecretVar("x=fd:three", NewExtStr), ErrInvalidValue))
+}
+
+func TestSetSecretVarFd(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no /dev/fd")
+	}
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	defer r.Close() //nolint:errcheck
+	_, err = w.WriteString("from fd\n")
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	m := VMVarMap{}
+	require.NoError(t, m.SetSecretVar("fd=fd:"+strconv.Itoa(int(r.Fd())), NewTLAStr))
+	require.Equal(t, NewTLAStr("from fd"), reveal(m["fd"]))
+}
@@ -0,0 +1,211 @@
+package jsonnext
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"foxygo.at/s/errs"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Sentinel errors wrapped by SignatureError. Callers can use errors.Is with
+// these sentinels to distinguish content that is not signed from content
+// that is signed incorrectly.
+var (
+	ErrUnsigned         = errors.New("unsigned")
+	ErrInvalidSignature = errors.New("invalid signature")
+)
+
+const (
+	minisigKeyIDLen  = 8
+	minisigAlgLen    = 2
+	minisigPubKeyLen = minisigAlgLen + minisigKeyIDLen + ed25519.PublicKeySize
+	minisigSigLen    = minisigAlgLen + minisigKeyIDLen + ed25519.SignatureSize
+)
+
+// SignatureFormat is the format of a detached signature file.
+type SignatureFormat int
+
+const (
+	// SigMinisign signatures are in the format written by minisign(1).
+	// Both legacy and pre-hashed signatures are supported, and the global
+	// signature over the trusted comment is verified. The signature of a
+	// file is fetched from the same netpath with ".minisig" appended.
+	SigMinisign SignatureFormat = iota
+
+	// SigEd25519 signatures are plain ed25519 signatures of the file
+	// contents, either as 64 raw bytes or base64 encoded. The signature of
+	// a file is fetched from the same netpath with ".sig" appended.
+	SigEd25519
+)
+
+// SignatureError is the error returned from Importer.Import when a netpath
+// file fails signature verification. Err is ErrUnsigned if no signature was
+// found, otherwise it wraps ErrInvalidSignature.
+type SignatureError struct {
+	Path string
+	Err  error
+}
+
+func (e *SignatureError) Error() string {
+	return fmt.Sprintf("could not verify %#v: %v", e.Path, e.Err)
+}
+
+// Unwrap returns the underlying error of the signature verification failure.
+func (e *SignatureError) Unwrap() error { return e.Err }
+
+// PublicKey is an ed25519 public key trusted to sign netpath files. KeyID is
+// the minisign key ID of the key. It is all zeros for raw ed25519 keys, in
+// which case the key ID of a minisign signature is not checked against it.
+type PublicKey struct {
+	Key   ed25519.PublicKey
+	KeyID [minisigKeyIDLen]byte
+}
+
+// ParsePublicKey parses a base64-encoded public key. The key can be a raw
+// ed25519 public key or a minisign public key. The contents of a minisign
+// public key file can be given as-is, as the "untrusted comment:" line is
+// skipped.
+func ParsePublicKey(s string) (PublicKey, error) {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[len(lines)-1]))
+	if err != nil {
+		return PublicKey{}, errs.Errorf("invalid public key: %v", err)
+	}
+
+	var pk PublicKey
+	switch {
+	case len(b) == ed25519.PublicKeySize:
+		pk.Key = ed25519.PublicKey(b)
+	case len(b) == minisigPubKeyLen && string(b[:minisigAlgLen]) == "Ed":
+		copy(pk.KeyID[:], b[minisigAlgLen:])
+		pk.Key = ed25519.PublicKey(b[minisigAlgLen+minisigKeyIDLen:])
+	default:
+		return PublicKey{}, errors.New("invalid public key: not an ed25519 or minisign key")
+	}
+	return pk, nil
+}
+
+// SignatureVerifier verifies the detached signatures of files imported from
+// netpaths by an Importer.
+type SignatureVerifier struct {
+	// Keys maps netpath prefixes to the public keys trusted to sign the
+	// files under that prefix. A prefix can be just a host, such as
+	// "//github.com", or a longer path, such as "//github.com/org/repo".
+	// Prefixes only match at path element boundaries and the longest
+	// matching prefix is used. Files that do not match any prefix are not
+	// verified. Files that do match must have a valid signature made by one
+	// of the keys for that prefix.
+	Keys map[string][]PublicKey
+
+	// Format is the format of the signature files.
+	Format SignatureFormat
+
+	// URLPattern is the netpath of the signature for a file, with "{path}"
+	// replaced by the netpath of the file without its leading "//". For
+	// example "//sigs.example.com/{path}.minisig". If empty, signatures are
+	// fetched from next to the file.
+	URLPattern string
+}
+
+// keys returns the trusted keys for the longest prefix of netpath p in Keys,
+// or nil if no prefix matches.
+func (sv *SignatureVerifier) keys(p string) []PublicKey {
+	var keys []PublicKey
+	longest := -1
+	for prefix, k := range sv.Keys {
+		if matchPrefix(p, prefix) && len(prefix) > longest {
+			keys, longest = k, len(prefix)
+		}
+	}
+	return keys
+}
+
+// sigPath returns the netpath of the signature for the file at netpath p.
+func (sv *SignatureVerifier) sigPath(p string) string {
+	if sv.URLPattern != "" {
+		return strings.ReplaceAll(sv.URLPattern, "{path}", strings.TrimPrefix(p, "//"))
+	}
+	if sv.Format == SigEd25519 {
+		return p + ".sig"
+	}
+	return p + ".minisig"
+}
+
+// verify verifies sig as a signature of msg made by one of keys.
+func (sv *SignatureVerifier) verify(keys []PublicKey, msg, sig []byte) error {
+	if sv.Format == SigEd25519 {
+		return verifyEd25519(keys, msg, sig)
+	}
+	return verifyMinisign(keys, msg, sig)
+}
+
+func verifyEd25519(keys []PublicKey, msg, sig []byte) error {
+	if len(sig) != ed25519.SignatureSize {
+		b, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig)))
+		if err != nil || len(b) != ed25519.SignatureSize {
+			return errs.Errorf("%v: malformed ed25519 signature", ErrInvalidSignature)
+		}
+		sig = b
+	}
+	for _, k := range keys {
+		if ed25519.Verify(k.Key, msg, sig) {
+			return nil
+		}
+	}
+	return errs.Errorf("%v: not signed by a trusted key", ErrInvalidSignature)
+}
+
+// verifyMinisign verifies a minisign signature file. The file consists of
+// four lines: an untrusted comment, the signature, a trusted comment and a
+// global signature of the signature and the trusted comment.
+func verifyMinisign(keys []PublicKey, msg, sigfile []byte) error {
+	lines := strings.Split(strings.TrimSpace(string(sigfile)), "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return errs.Errorf("%v: malformed minisign signature", ErrInvalidSignature)
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(sig) != minisigSigLen {
+		return errs.Errorf("%v: malformed minisign signature", ErrInvalidSignature)
+	}
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return errs.Errorf("%v: malformed minisign global signature", ErrInvalidSignature)
+	}
+
+	switch string(sig[:minisigAlgLen]) {
+	case "Ed": // legacy: sign the message itself
+	case "ED": // pre-hashed: sign the blake2b-512 hash of the message
+		h := blake2b.Sum512(msg)
+		msg = h[:]
+	default:
+		return errs.Errorf("%v: unknown minisign algorithm %q", ErrInvalidSignature, sig[:minisigAlgLen])
+	}
+
+	var keyID [minisigKeyIDLen]byte
+	copy(keyID[:], sig[minisigAlgLen:])
+	sig = sig[minisigAlgLen+minisigKeyIDLen:]
+	trusted := strings.TrimSuffix(strings.TrimPrefix(lines[2], "trusted comment: "), "\r")
+	for _, k := range keys {
+		if k.KeyID != keyID && k.KeyID != [minisigKeyIDLen]byte{} {
+			continue
+		}
+		if ed25519.Verify(k.Key, msg, sig) && ed25519.Verify(k.Key, append(sig, trusted...), global) {
+			return nil
+		}
+	}
+	return errs.Errorf("%v: not signed by a trusted key", ErrInvalidSignature)
+}
+
+// matchPrefix returns true if prefix is a leading part of netpath p, ending
+// at a path element boundary.
+func matchPrefix(p, prefix string) bool {
+	if !strings.HasPrefix(p, prefix) {
+		return false
+	}
+	return len(p) == len(prefix) || strings.HasSuffix(prefix, "/") || p[len(prefix)] == '/'
+}
@@ -0,0 +1,177 @@
+package jsonnext
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/blake2b"
+)
+
+type fileMap map[string]string
+
+func (fm fileMap) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	content, ok := fm[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	_, _ = io.WriteString(w, content)
+}
+
+var (
+	testKeyID   = [minisigKeyIDLen]byte{1, 2, 3, 4, 5, 6, 7, 8}
+	testPrivKey = ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+	testPubKey  = PublicKey{Key: testPrivKey.Public().(ed25519.PublicKey), KeyID: testKeyID}
+	otherKey    = ed25519.NewKeyFromSeed(bytes.Repeat([]byte{2}, ed25519.SeedSize))
+)
+
+func signEd25519(key ed25519.PrivateKey, msg string) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(key, []byte(msg)))
+}
+
+func signMinisign(key ed25519.PrivateKey, alg, msg string) string {
+	m := []byte(msg)
+	if alg == "ED" {
+		h := blake2b.Sum512(m)
+		m = h[:]
+	}
+	sig := ed25519.Sign(key, m)
+	trusted := "timestamp:0\tfile:hello.txt"
+	global := ed25519.Sign(key, append(append([]byte{}, sig...), trusted...))
+	b := append(append([]byte(alg), testKeyID[:]...), sig...)
+	return "untrusted comment: test signature\n" +
+		base64.StdEncoding.EncodeToString(b) + "\n" +
+		"trusted comment: " + trusted + "\n" +
+		base64.StdEncoding.EncodeToString(global) + "\n"
+}
+
+func TestImportSignature(t *testing.T) {
+	const content = "hello world\n"
+	tests := map[string]struct {
+		files   fileMap
+		format  SignatureFormat
+		pattern string
+		err     error
+	}{
+		"minisign-prehashed": {
+			files: fileMap{"/lib/hello.txt.minisig": signMinisign(testPrivKey, "ED", content)},
+		},
+		"minisign-legacy": {
+			files: fileMap{"/lib/hello.txt.minisig": signMinisign(testPrivKey, "Ed", content)},
+		},
+		"minisign-wrong-key": {
+			files: fileMap{"/lib/hello.txt.minisig": signMinisign(otherKey, "ED", content)},
+			err:   ErrInvalidSignature,
+		},
+		"minisign-malformed": {
+			files: fileMap{"/lib/hello.txt.minisig": "not a signature"},
+			err:   ErrInvalidSignature,
+		},
+		"ed25519": {
+			files:  fileMap{"/lib/hello.txt.sig": signEd25519(testPrivKey, content)},
+			format: SigEd25519,
+		},
+		"ed25519-raw": {
+			files:  fileMap{"/lib/hello.txt.sig": string(ed25519.Sign(testPrivKey, []byte(content)))},
+			format: SigEd25519,
+		},
+		"ed25519-wrong-content": {
+			files:  fileMap{"/lib/hello.txt.sig": signEd25519(testPrivKey, "goodbye world\n")},
+			format: SigEd25519,
+			err:    ErrInvalidSignature,
+		},
+		"pattern": {
+			files:   fileMap{"/sigs/{host}/lib/hello.txt.sig": signEd25519(testPrivKey, content)},
+			format:  SigEd25519,
+			pattern: "/sigs/{path}.sig",
+		},
+		"unsigned": {
+			files: fileMap{},
+			err:   ErrUnsigned,
+		},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			tc.files["/lib/hello.txt"] = content
+			s := httptest.NewTLSServer(tc.files)
+			defer s.Close()
+			np := strings.TrimPrefix(s.URL, "https:")
+			for k, v := range tc.files {
+				if strings.Contains(k, "{host}") {
+					tc.files[strings.ReplaceAll(k, "{host}", strings.TrimPrefix(np, "//"))] = v
+				}
+			}
+
+			sv := &SignatureVerifier{
+				Keys:   map[string][]PublicKey{np + "/lib": {testPubKey}},
+				Format: tc.format,
+			}
+			if tc.pattern != "" {
+				sv.URLPattern = np + tc.pattern
+			}
+			i := Importer{Fetcher: s.Client(), Signatures: sv}
+			contents, _, err := i.Import("", np+"/lib/hello.txt")
+			if tc.err == nil {
+				require.NoError(t, err)
+				require.Equal(t, content, contents.String())
+				return
+			}
+			require.Error(t, err)
+			require.True(t, errors.Is(err, tc.err), "error should be %v: %v", tc.err, err)
+			var sigErr *SignatureError
+			require.True(t, errors.As(err, &sigErr), "error should be a *SignatureError")
+			require.Equal(t, np+"/lib/hello.txt", sigErr.Path)
+		})
+	}
+}
+
+func TestImportSignatureNotRequired(t *testing.T) {
+	s := httptest.NewTLSServer(fileMap{"/lib/hello.txt": "hello world\n"})
+	defer s.Close()
+	np := strings.TrimPrefix(s.URL, "https:")
+
+	sv := &SignatureVerifier{Keys: map[string][]PublicKey{np + "/other": {testPubKey}}}
+	i := Importer{Fetcher: s.Client(), Signatures: sv}
+	contents, _, err := i.Import("", np+"/lib/hello.txt")
+	require.NoError(t, err)
+	require.Equal(t, "hello world\n", contents.String())
+}
+
+func TestSignatureKeysLongestPrefix(t *testing.T) {
+	k1, k2 := []PublicKey{{KeyID: [8]byte{1}}}, []PublicKey{{KeyID: [8]byte{2}}}
+	sv := SignatureVerifier{Keys: map[string][]PublicKey{
+		"//example.com":          k1,
+		"//example.com/org/repo": k2,
+	}}
+	require.Equal(t, k1, sv.keys("//example.com/org/other/x.libsonnet"))
+	require.Equal(t, k2, sv.keys("//example.com/org/repo/x.libsonnet"))
+	require.Nil(t, sv.keys("//example.com.au/org/repo/x.libsonnet"))
+	require.Equal(t, k1, sv.keys("//example.com/org/repository/x.libsonnet"))
+}
+
+func TestParsePublicKey(t *testing.T) {
+	raw := base64.StdEncoding.EncodeToString(testPubKey.Key)
+	pk, err := ParsePublicKey(raw)
+	require.NoError(t, err)
+	require.Equal(t, PublicKey{Key: testPubKey.Key}, pk)
+
+	b := append(append([]byte("Ed"), testKeyID[:]...), testPubKey.Key...)
+	minisign := "untrusted comment: minisign public key\n" + base64.StdEncoding.EncodeToString(b) + "\n"
+	pk, err = ParsePublicKey(minisign)
+	require.NoError(t, err)
+	require.Equal(t, testPubKey, pk)
+
+	_, err = ParsePublicKey("not base64!")
+	require.Error(t, err)
+	_, err = ParsePublicKey(base64.StdEncoding.EncodeToString([]byte("short")))
+	require.Error(t, err)
+}
@@ -0,0 +1 @@
+"local lib"
@@ -0,0 +1 @@
+"project lib"
@@ -0,0 +1 @@
+std.thisFile
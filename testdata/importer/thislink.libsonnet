@@ -0,0 +1 @@
+this.libsonnet
\ No newline at end of file
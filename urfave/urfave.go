@@ -0,0 +1,95 @@
+// Package urfave parses command line flags into jsonnext.Config using
+// urfave/cli.
+//
+//   import "github.com/urfave/cli/v2"
+//
+// urfave/cli is a package for building command line apps from cli.Flag and
+// cli.Command definitions. This package provides the cli.Flag definitions for
+// the fields of a jsonnext.Config. They are the flags described for
+// jsonnext.ConfigFlags. Add them to the Flags of an app or command and get the
+// Config in its action:
+//
+//   app := &cli.App{
+//       Flags: jnxurfave.ConfigFlags(),
+//       Action: func(ctx *cli.Context) error {
+//           cfg, err := jnxurfave.Config(ctx)
+//           ...
+//       },
+//   }
+//
+// This functionality is split into a separate sub-package so users of jsonnext
+// do not need to depend on urfave/cli if they do not use it.
+package urfave
+
+import (
+	"errors"
+	"flag"
+
+	"foxygo.at/jsonnext"
+	"github.com/urfave/cli/v2"
+)
+
+// ErrNoConfigFlags is returned by Config when the flags from ConfigFlags are
+// not defined for the cli.Context.
+var ErrNoConfigFlags = errors.New("jsonnext config flags not defined")
+
+// ConfigFlags returns cli.Flag definitions for the fields of a new
+// jsonnext.Config, which is returned by Config from a cli.Context the flags
+// have been parsed into.
+func ConfigFlags() []cli.Flag {
+	return ConfigFlagsVar(jsonnext.NewConfig())
+}
+
+// ConfigFlagsVar returns cli.Flag definitions for the fields of the Config c.
+// The values of the flags are set in c when the command line is parsed.
+//
+// The short flags, such as -J, are separate flags sharing the value of their
+// long flag rather than aliases of it, as urfave/cli does not allow both forms
+// of a flag on one command line, and copies the value of a flag to its aliases
+// by setting it again, which would add repeated values twice.
+func ConfigFlagsVar(c *jsonnext.Config) []cli.Flag {
+	// Define the flags with the flag package and convert them, so the
+	// values are parsed exactly as they are by jsonnext.ConfigFlagsVar.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	jsonnext.ConfigFlagsVar(fs, c)
+	var flags []cli.Flag
+	fs.VisitAll(func(f *flag.Flag) {
+		flags = append(flags, &cli.GenericFlag{
+			Name:  f.Name,
+			Usage: f.Usage,
+			Value: &value{f.Value, c},
+		})
+	})
+	return flags
+}
+
+// Config returns the jsonnext.Config holding the values of the flags from
+// ConfigFlags or ConfigFlagsVar that ctx has parsed, after filling any fields
+// not set on the command line from the environment, as described for
+// jsonnext.Config.LoadEnv with the prefix jsonnext.EnvPrefix. ctx may be the
+// context of a subcommand of the command the flags are defined for.
+func Config(ctx *cli.Context) (*jsonnext.Config, error) {
+	v, ok := ctx.Generic("jpath").(*value)
+	if !ok {
+		return nil, ErrNoConfigFlags
+	}
+	return v.c, v.c.LoadEnv(jsonnext.EnvPrefix)
+}
+
+// value adapts a flag.Value to a cli.Generic, keeping the Config the value
+// is set in so Config can find it.
+type value struct {
+	flag.Value
+	c *jsonnext.Config
+}
+
+// String returns a string representation of v, or empty if v is empty or
+// zero, so that urfave/cli does not show them as defaults in the usage.
+func (v *value) String() string {
+	s := v.Value.String()
+	switch s {
+	case "[]", "map[]", "0", "0s":
+		return ""
+	}
+	return s
+}
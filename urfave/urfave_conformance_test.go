@@ -0,0 +1,36 @@
+package urfave_test
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+
+	"foxygo.at/jsonnext"
+	"foxygo.at/jsonnext/conformance"
+	jnxurfave "foxygo.at/jsonnext/urfave"
+)
+
+type suite struct{}
+
+func (s *suite) Parse(t *testing.T, args []string) (*jsonnext.Config, error) {
+	var cfg *jsonnext.Config
+	app := &cli.App{
+		Name:      args[0],
+		Flags:     jnxurfave.ConfigFlags(),
+		Writer:    ioutil.Discard,
+		ErrWriter: ioutil.Discard,
+		Action: func(ctx *cli.Context) error {
+			var err error
+			cfg, err = jnxurfave.Config(ctx)
+			return err
+		},
+	}
+	err := app.Run(args)
+	return cfg, err
+}
+
+func TestConformance(t *testing.T) {
+	s := conformance.NewSuite(&suite{})
+	s.Run(t)
+}
@@ -0,0 +1,35 @@
+package urfave
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestConfigNoFlags(t *testing.T) {
+	app := &cli.App{
+		Writer: ioutil.Discard,
+		Action: func(ctx *cli.Context) error {
+			_, err := Config(ctx)
+			return err
+		},
+	}
+	err := app.Run([]string{t.Name()})
+	require.True(t, errors.Is(err, ErrNoConfigFlags), "%v", err)
+}
+
+func TestUsage(t *testing.T) {
+	out := &bytes.Buffer{}
+	app := &cli.App{Flags: ConfigFlags(), Writer: out}
+	require.NoError(t, app.Run([]string{t.Name(), "--help"}))
+	usage := out.String()
+	require.Contains(t, usage, "--jpath dir")
+	require.Contains(t, usage, "-J dir")
+	require.Contains(t, usage, "(default: 500)")
+	require.NotContains(t, usage, "map[]")
+	require.NotContains(t, usage, "(default: 0")
+}
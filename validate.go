@@ -0,0 +1,191 @@
+package jsonnext
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"foxygo.at/s/errs"
+	jsonnet "github.com/google/go-jsonnet"
+)
+
+// Sentinel errors for the problems found by Config.Check. Callers can use
+// errors.Is on the error returned by Check to check for each of them.
+var (
+	ErrInvalidCode  = errors.New("invalid code")
+	ErrMissingFile  = errors.New("file does not exist")
+	ErrMissingDir   = errors.New("import path directory does not exist")
+	ErrStrCodeClash = errors.New("var set as both string and code")
+)
+
+// ValidationError holds all the problems found by Config.Check, or the TLA
+// problems found by Config.CheckTLAs. Errs are problems that will cause
+// evaluation to fail. Warnings are problems that are likely to be mistakes
+// but do not stop evaluation.
+type ValidationError struct {
+	Errs     []error
+	Warnings []error
+}
+
+// Error returns all the problems in e, one per line.
+func (e *ValidationError) Error() string {
+	lines := make([]string, 0, len(e.Errs)+len(e.Warnings)+1)
+	lines = append(lines, "invalid config:")
+	for _, err := range e.Errs {
+		lines = append(lines, "  "+err.Error())
+	}
+	for _, err := range e.Warnings {
+		lines = append(lines, "  warning: "+err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Is returns true if any of the errors or warnings in e is target.
+func (e *ValidationError) Is(target error) bool {
+	for _, err := range e.Errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	for _, err := range e.Warnings {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// PrintWarnings writes the warnings of err to w, one per line, if it is a
+// *ValidationError holding only warnings, and returns nil so that a program
+// can carry on. Any other err is returned as it is.
+func PrintWarnings(w io.Writer, err error) error {
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errs) != 0 {
+		return err
+	}
+	for _, warning := range verr.Warnings {
+		if _, err := fmt.Fprintln(w, "warning:", warning); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check checks c for problems that would otherwise only show up when
+// evaluating jsonnet with a VM configured from c:
+//   - code vars that do not parse as jsonnet
+//   - file vars whose file does not exist in the current directory or the
+//     import path
+//   - import path directories that do not exist
+//
+// It also warns about a var name set as a string in one of ExtVars and
+// TLAVars and as code in the other. Within each of them, a later setting of a
+// name replaces an earlier one, so that cannot be detected.
+//
+// Netpaths in the import path and file vars are not checked. If any problems
+// are found, a *ValidationError holding all of them is returned, otherwise
+// nil. Use PrintWarnings to carry on if there are only warnings.
+//
+// Check is meant to be called once c is complete, after it has been filled
+// from the environment and project config file. It is not named Validate so
+// that kong does not call it while parsing the command line.
+func (c *Config) Check() error {
+	e := &ValidationError{}
+	for _, dir := range c.ImportPath {
+		if isNetpath(dir) {
+			continue
+		}
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			e.Errs = append(e.Errs, errs.Errorf("%v: %s", ErrMissingDir, dir))
+		}
+	}
+	e.Errs = append(e.Errs, c.validateVars(c.ExtVars)...)
+	e.Errs = append(e.Errs, c.validateVars(c.TLAVars)...)
+
+	for _, name := range sortedNames(c.ExtVars) {
+		tla, ok := c.TLAVars[name]
+		if ok && isCodeKind(c.ExtVars[name].Kind()) != isCodeKind(tla.Kind()) {
+			e.Warnings = append(e.Warnings, errs.Errorf("%v: %s (--%s and --%s)", ErrStrCodeClash, name, c.ExtVars[name].Kind(), tla.Kind()))
+		}
+	}
+
+	if len(e.Errs) == 0 && len(e.Warnings) == 0 {
+		return nil
+	}
+	return e
+}
+
+// CheckWithEnv is like Check, but also checks the directories of the import
+// path from the environment variable pathEnvVar, which ConfigureImporter adds
+// after ImportPath.
+func (c *Config) CheckWithEnv(pathEnvVar string) error {
+	i := &Importer{}
+	c.ConfigureImporter(i, pathEnvVar)
+	v := *c
+	v.ImportPath = i.SearchPath
+	return v.Check()
+}
+
+func (c *Config) validateVars(m VMVarMap) []error {
+	var result []error
+	for _, name := range sortedNames(m) {
+		v := m[name]
+		switch v.Kind() {
+		case KindExtCode, KindTLACode:
+			_, err := jsonnet.SnippetToAST("<"+string(v.Kind())+":"+name+">", reveal(v).Value())
+			switch {
+			case err != nil && IsSecret(v):
+				// The parse error may quote the code.
+				result = append(result, errs.Errorf("%v: --%s %s: %s", ErrInvalidCode, v.Kind(), name, Redacted))
+			case err != nil:
+				result = append(result, errs.Errorf("%v: --%s %s: %v", ErrInvalidCode, v.Kind(), name, err))
+			}
+		case KindExtStrFile, KindExtCodeFile, KindTLAStrFile, KindTLACodeFile:
+			if !c.fileExists(reveal(v).Value()) {
+				result = append(result, errs.Errorf("%v: --%s %s: %s", ErrMissingFile, v.Kind(), name, v.Value()))
+			}
+		}
+	}
+	return result
+}
+
+// fileExists returns true if the file var filename exists in the current
+// directory or any of the local directories in the import path. Netpaths are
+// assumed to exist.
+func (c *Config) fileExists(filename string) bool {
+	if isNetpath(filename) {
+		return true
+	}
+	if _, err := os.Stat(filename); err == nil {
+		return true
+	}
+	if filepath.IsAbs(filename) {
+		return false
+	}
+	for _, dir := range c.ImportPath {
+		if isNetpath(dir) {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, filename)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func isCodeKind(k VMVarKind) bool {
+	return strings.Contains(string(k), "-code")
+}
+
+func sortedNames(m VMVarMap) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
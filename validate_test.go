@@ -0,0 +1,79 @@
+package jsonnext
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"foxygo.at/s/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	c := NewConfig()
+	c.ImportPath = []string{"testdata/importer", "//example.com/lib"}
+	c.ExtVars["code"] = NewExtCode("{ a: 1 }")
+	c.ExtVars["file"] = NewExtStrFile("testdata/importer/hello.txt")
+	c.TLAVars["searched"] = NewTLACodeFile("mellow.txt")
+	c.TLAVars["net"] = NewTLAStrFile("//example.com/lib/x.libsonnet")
+	require.NoError(t, c.Check())
+}
+
+func TestCheckErrors(t *testing.T) {
+	c := NewConfig()
+	c.ImportPath = []string{"testdata/importer", "testdata/missing", "testdata/importer/hello.txt"}
+	c.ExtVars["code"] = NewExtCode("{ a: 1 ")
+	c.ExtVars["file"] = NewExtCodeFile("missing.jsonnet")
+	c.TLAVars["code"] = NewTLACode("}")
+	c.TLAVars["found"] = NewTLAStrFile("hello.txt")
+
+	err := c.Check()
+	require.Error(t, err)
+	var verr *ValidationError
+	require.True(t, errors.As(err, &verr))
+	require.Equal(t, 5, len(verr.Errs), err.Error())
+	require.True(t, errors.Is(verr.Errs[0], ErrMissingDir))
+	require.True(t, errors.Is(verr.Errs[1], ErrMissingDir))
+	require.True(t, errors.Is(verr.Errs[2], ErrInvalidCode))
+	require.True(t, errors.Is(verr.Errs[3], ErrMissingFile))
+	require.True(t, errors.Is(verr.Errs[4], ErrInvalidCode))
+	require.Equal(t, 0, len(verr.Warnings))
+	require.True(t, errors.Is(err, ErrInvalidCode))
+	require.False(t, errors.Is(err, ErrStrCodeClash))
+	require.False(t, errors.Is(err, ErrMissingTLA))
+
+	var buf bytes.Buffer
+	require.Equal(t, err, PrintWarnings(&buf, err))
+	require.Equal(t, "", buf.String())
+}
+
+func TestCheckWarnings(t *testing.T) {
+	c := NewConfig()
+	c.ExtVars["env"] = NewExtStr("dev")
+	c.TLAVars["env"] = NewTLACode("'dev'")
+	c.ExtVars["same"] = NewExtStr("a")
+	c.TLAVars["same"] = NewTLAStr("b")
+
+	err := c.Check()
+	var verr *ValidationError
+	require.True(t, errors.As(err, &verr))
+	require.Equal(t, 0, len(verr.Errs))
+	require.Equal(t, 1, len(verr.Warnings))
+	require.True(t, errors.Is(err, ErrStrCodeClash))
+
+	var buf bytes.Buffer
+	require.NoError(t, PrintWarnings(&buf, err))
+	require.Equal(t, "warning: "+verr.Warnings[0].Error()+"\n", buf.String())
+}
+
+func TestCheckWithEnv(t *testing.T) {
+	test.Env.Set("JPATH", "testdata/importer:testdata/missing")
+	defer test.Env.Restore()
+	c := NewConfig()
+	c.ImportPath = []string{"testdata"}
+	require.NoError(t, c.Check())
+	err := c.CheckWithEnv("JPATH")
+	require.True(t, errors.Is(err, ErrMissingDir), "%v", err)
+	require.Contains(t, err.Error(), "testdata/missing")
+	require.NoError(t, c.CheckWithEnv(""))
+}